@@ -0,0 +1,170 @@
+// Package audio
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hajimehoshi/go-mp3"
+)
+
+// Format describes interleaved, signed 16-bit little-endian PCM.
+type Format struct {
+	SampleRate int
+	Channels   int
+}
+
+// FrameSize returns the size of one frame, i.e. one sample for each channel, in bytes.
+func (f Format) FrameSize() int {
+	return f.Channels * 2
+}
+
+// Duration returns the playing time of the given number of frames.
+func (f Format) Duration(frames int64) time.Duration {
+	if f.SampleRate <= 0 {
+		return 0
+	}
+	return time.Duration(frames) * time.Second / time.Duration(f.SampleRate)
+}
+
+// Decoder decodes MP3 audio, as returned by the Kindroid audio inference, into PCM on the fly.
+// The decoded PCM is always stereo, even if the MP3 is mono, since both channels are output by the decoder.
+type Decoder struct {
+	mp3    *mp3.Decoder
+	format Format
+}
+
+// NewDecoder creates a decoder reading MP3 audio from r. The first frame is read to determine the sample rate.
+func NewDecoder(r io.Reader) (*Decoder, error) {
+	d, err := mp3.NewDecoder(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode MP3: %w", err)
+	}
+	return &Decoder{mp3: d, format: Format{SampleRate: d.SampleRate(), Channels: 2}}, nil
+}
+
+// Format returns the format of the decoded PCM.
+func (d *Decoder) Format() Format {
+	return d.format
+}
+
+// Read implements io.Reader, reading decoded PCM.
+func (d *Decoder) Read(p []byte) (int, error) {
+	return d.mp3.Read(p)
+}
+
+// Frames returns the total number of PCM frames, or -1 if it is unknown because the source is not an io.Seeker.
+func (d *Decoder) Frames() int64 {
+	length := d.mp3.Length()
+	if length < 0 {
+		return -1
+	}
+	return length / int64(d.format.FrameSize())
+}
+
+// Buffer holds decoded PCM in memory.
+type Buffer struct {
+	Format Format
+	// Samples are interleaved by channel.
+	Samples []int16
+}
+
+// Decode decodes MP3 audio from r completely.
+func Decode(r io.Reader) (*Buffer, error) {
+	d, err := NewDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	pcm, err := io.ReadAll(d)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode MP3: %w", err)
+	}
+	return NewBuffer(d.Format(), pcm)
+}
+
+// NewBuffer creates a buffer from signed 16-bit little-endian PCM.
+func NewBuffer(format Format, pcm []byte) (*Buffer, error) {
+	if format.SampleRate <= 0 || format.Channels <= 0 {
+		return nil, fmt.Errorf("invalid PCM format: %d Hz, %d channels", format.SampleRate, format.Channels)
+	}
+	if len(pcm)%format.FrameSize() != 0 {
+		return nil, errors.New("PCM data does not end on a frame boundary")
+	}
+	samples := make([]int16, len(pcm)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(pcm[2*i:]))
+	}
+	return &Buffer{Format: format, Samples: samples}, nil
+}
+
+// Frames returns the number of PCM frames in the buffer.
+func (b *Buffer) Frames() int {
+	return len(b.Samples) / b.Format.Channels
+}
+
+// Duration returns the playing time of the buffer.
+func (b *Buffer) Duration() time.Duration {
+	return b.Format.Duration(int64(b.Frames()))
+}
+
+// Bytes returns the PCM as signed 16-bit little-endian bytes.
+func (b *Buffer) Bytes() []byte {
+	pcm := make([]byte, 2*len(b.Samples))
+	for i, sample := range b.Samples {
+		binary.LittleEndian.PutUint16(pcm[2*i:], uint16(sample))
+	}
+	return pcm
+}
+
+// Mono returns the buffer mixed down to a single channel by averaging the channels.
+func (b *Buffer) Mono() *Buffer {
+	channels := b.Format.Channels
+	if channels == 1 {
+		return b
+	}
+	mono := make([]int16, b.Frames())
+	for i := range mono {
+		var sum int
+		for _, sample := range b.Samples[i*channels : (i+1)*channels] {
+			sum += int(sample)
+		}
+		mono[i] = int16(sum / channels)
+	}
+	return &Buffer{Format: Format{SampleRate: b.Format.SampleRate, Channels: 1}, Samples: mono}
+}
+
+// Duration returns the playing time of MP3 audio.
+// If r is an io.Seeker, only the frame headers are read; otherwise the audio is decoded completely.
+func Duration(r io.Reader) (time.Duration, error) {
+	d, err := NewDecoder(r)
+	if err != nil {
+		return 0, err
+	}
+	frames := d.Frames()
+	if frames < 0 {
+		size, err := io.Copy(io.Discard, d)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decode MP3: %w", err)
+		}
+		frames = size / int64(d.Format().FrameSize())
+	}
+	return d.Format().Duration(frames), nil
+}
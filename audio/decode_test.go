@@ -0,0 +1,109 @@
+// Package audio
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audio
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// speechFrames is the number of PCM frames in testdata/speech.mp3: 40 MPEG-2 frames of 576 samples.
+const speechFrames = 40 * 576
+
+func readFixture(t *testing.T) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/speech.mp3")
+	require.NoError(t, err)
+	return data
+}
+
+func TestDecode(t *testing.T) {
+	buf, err := Decode(bytes.NewReader(readFixture(t)))
+	require.NoError(t, err)
+	assert.Equal(t, Format{SampleRate: 22050, Channels: 2}, buf.Format)
+	assert.Equal(t, speechFrames, buf.Frames())
+	assert.Equal(t, 1044897959*time.Nanosecond, buf.Duration())
+
+	// The fixture contains speech, not silence
+	var peak int16
+	for _, sample := range buf.Samples {
+		peak = max(peak, sample, -sample)
+	}
+	assert.Greater(t, peak, int16(1000))
+
+	// Mono MP3 is decoded to identical channels
+	mono := buf.Mono()
+	assert.Equal(t, Format{SampleRate: 22050, Channels: 1}, mono.Format)
+	assert.Equal(t, speechFrames, len(mono.Samples))
+	assert.Equal(t, buf.Samples[2000], mono.Samples[1000])
+
+	_, err = Decode(bytes.NewReader([]byte("<html>Access denied</html>")))
+	assert.Error(t, err)
+}
+
+func TestDecoder(t *testing.T) {
+	data := readFixture(t)
+	buffered, err := Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	// Streaming yields the same PCM, also if the source returns data in small chunks
+	d, err := NewDecoder(iotest.HalfReader(bytes.NewReader(data)))
+	require.NoError(t, err)
+	assert.Equal(t, buffered.Format, d.Format())
+	assert.Equal(t, int64(-1), d.Frames(), "the length of a stream is unknown")
+	pcm, err := io.ReadAll(d)
+	require.NoError(t, err)
+	assert.Equal(t, buffered.Bytes(), pcm)
+
+	d, err = NewDecoder(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, int64(speechFrames), d.Frames())
+}
+
+func TestDuration(t *testing.T) {
+	data := readFixture(t)
+	seekable, err := Duration(bytes.NewReader(data))
+	require.NoError(t, err)
+	streamed, err := Duration(iotest.OneByteReader(bytes.NewReader(data)))
+	require.NoError(t, err)
+	assert.Equal(t, seekable, streamed)
+	assert.InDelta(t, 1.045, seekable.Seconds(), 0.001)
+
+	assert.Equal(t, 1500*time.Millisecond, Format{SampleRate: 24000, Channels: 1}.Duration(36000))
+}
+
+func TestNewBuffer(t *testing.T) {
+	buf, err := NewBuffer(Format{SampleRate: 8000, Channels: 2}, []byte{0x01, 0x00, 0xff, 0xff, 0x00, 0x80, 0xff, 0x7f})
+	require.NoError(t, err)
+	assert.Equal(t, []int16{1, -1, -32768, 32767}, buf.Samples)
+	assert.Equal(t, 2, buf.Frames())
+	assert.Equal(t, []byte{0x01, 0x00, 0xff, 0xff, 0x00, 0x80, 0xff, 0x7f}, buf.Bytes())
+	assert.Equal(t, []int16{0, 0}, buf.Mono().Samples)
+
+	_, err = NewBuffer(Format{SampleRate: 8000, Channels: 2}, []byte{0x01, 0x00})
+	assert.Error(t, err)
+	_, err = NewBuffer(Format{}, nil)
+	assert.Error(t, err)
+}
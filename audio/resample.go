@@ -0,0 +1,51 @@
+// Package audio
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package audio
+
+import (
+	"fmt"
+	"math"
+)
+
+// Resample returns the buffer converted to the given sample rate using linear interpolation.
+// This is meant for speech processing like voice activity detection, not for high fidelity playback.
+func (b *Buffer) Resample(sampleRate int) (*Buffer, error) {
+	if sampleRate <= 0 {
+		return nil, fmt.Errorf("invalid sample rate: %d", sampleRate)
+	}
+	if sampleRate == b.Format.SampleRate {
+		return b, nil
+	}
+
+	channels := b.Format.Channels
+	frames := b.Frames()
+	outFrames := int(math.Round(float64(frames) * float64(sampleRate) / float64(b.Format.SampleRate)))
+	out := make([]int16, outFrames*channels)
+	step := float64(b.Format.SampleRate) / float64(sampleRate)
+	for i := range outFrames {
+		pos := float64(i) * step
+		index := int(pos)
+		frac := pos - float64(index)
+		next := min(index+1, frames-1)
+		for c := range channels {
+			a := float64(b.Samples[index*channels+c])
+			z := float64(b.Samples[next*channels+c])
+			out[i*channels+c] = int16(math.Round(a + (z-a)*frac))
+		}
+	}
+	return &Buffer{Format: Format{SampleRate: sampleRate, Channels: channels}, Samples: out}, nil
+}
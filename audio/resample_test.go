@@ -0,0 +1,58 @@
+// Package audio
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audio
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResample(t *testing.T) {
+	buf := &Buffer{Format: Format{SampleRate: 4, Channels: 2}, Samples: []int16{0, 100, 400, 500, 800, 900, 1200, 1300}}
+
+	up, err := buf.Resample(8)
+	require.NoError(t, err)
+	assert.Equal(t, Format{SampleRate: 8, Channels: 2}, up.Format)
+	assert.Equal(t, []int16{0, 100, 200, 300, 400, 500, 600, 700, 800, 900, 1000, 1100, 1200, 1300, 1200, 1300}, up.Samples)
+
+	down, err := buf.Resample(2)
+	require.NoError(t, err)
+	assert.Equal(t, []int16{0, 100, 800, 900}, down.Samples)
+
+	same, err := buf.Resample(4)
+	require.NoError(t, err)
+	assert.Same(t, buf, same)
+
+	_, err = buf.Resample(0)
+	assert.Error(t, err)
+}
+
+func TestResampleFixture(t *testing.T) {
+	buf, err := Decode(bytes.NewReader(readFixture(t)))
+	require.NoError(t, err)
+
+	// Common rate of voice activity detection models
+	resampled, err := buf.Mono().Resample(16000)
+	require.NoError(t, err)
+	assert.Equal(t, Format{SampleRate: 16000, Channels: 1}, resampled.Format)
+	assert.Equal(t, 16718, resampled.Frames())
+	assert.InDelta(t, buf.Duration().Seconds(), resampled.Duration().Seconds(), 0.001)
+}
@@ -0,0 +1,124 @@
+// Package audio
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// wavHeaderSize is the size of the RIFF, fmt and data chunk headers written by WriteWAV.
+const wavHeaderSize = 44
+
+// WriteWAV writes the buffer as a 16-bit PCM WAV file.
+func WriteWAV(w io.Writer, b *Buffer) error {
+	if err := writeWAVHeader(w, b.Format, int64(2*len(b.Samples))); err != nil {
+		return err
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// EncodeWAV reads PCM of the given format from r, e.g. a Decoder, and writes it as a WAV file.
+// Since the header contains the size of the audio, the PCM is buffered in memory unless w is a seekable
+// io.WriteSeeker like a regular file, in which case the header is completed after streaming the audio.
+func EncodeWAV(w io.Writer, format Format, r io.Reader) error {
+	if ws, ok := w.(io.WriteSeeker); ok {
+		// Pipes and terminals are *os.File too, but cannot seek
+		if start, err := ws.Seek(0, io.SeekCurrent); err == nil {
+			return streamWAV(ws, start, format, r)
+		}
+	}
+	pcm, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	b, err := NewBuffer(format, pcm)
+	if err != nil {
+		return err
+	}
+	return WriteWAV(w, b)
+}
+
+// streamWAV copies the PCM to w and then seeks back to write the final header.
+func streamWAV(w io.WriteSeeker, start int64, format Format, r io.Reader) error {
+	// Placeholder, replaced once the size is known
+	if err := writeWAVHeader(w, format, 0); err != nil {
+		return err
+	}
+	size, err := io.Copy(w, r)
+	if err != nil {
+		return err
+	}
+	if size%int64(format.FrameSize()) != 0 {
+		return errors.New("PCM data does not end on a frame boundary")
+	}
+	if _, err = w.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	if err = writeWAVHeader(w, format, size); err != nil {
+		return err
+	}
+	_, err = w.Seek(start+wavHeaderSize+size, io.SeekStart)
+	return err
+}
+
+// wavHeader is the header of a canonical PCM WAV file.
+type wavHeader struct {
+	RIFF          [4]byte
+	RIFFSize      uint32
+	WAVE          [4]byte
+	Fmt           [4]byte
+	FmtSize       uint32
+	AudioFormat   uint16
+	Channels      uint16
+	SampleRate    uint32
+	ByteRate      uint32
+	BlockAlign    uint16
+	BitsPerSample uint16
+	Data          [4]byte
+	DataSize      uint32
+}
+
+func writeWAVHeader(w io.Writer, format Format, dataSize int64) error {
+	if format.SampleRate <= 0 || format.Channels <= 0 {
+		return fmt.Errorf("invalid PCM format: %d Hz, %d channels", format.SampleRate, format.Channels)
+	}
+	if dataSize > math.MaxUint32-wavHeaderSize+8 {
+		return errors.New("audio is too large for a WAV file")
+	}
+
+	header := wavHeader{
+		RIFF:          [4]byte{'R', 'I', 'F', 'F'},
+		RIFFSize:      uint32(wavHeaderSize - 8 + dataSize),
+		WAVE:          [4]byte{'W', 'A', 'V', 'E'},
+		Fmt:           [4]byte{'f', 'm', 't', ' '},
+		FmtSize:       16,
+		AudioFormat:   1, // PCM
+		Channels:      uint16(format.Channels),
+		SampleRate:    uint32(format.SampleRate),
+		ByteRate:      uint32(format.SampleRate * format.FrameSize()),
+		BlockAlign:    uint16(format.FrameSize()),
+		BitsPerSample: 16,
+		Data:          [4]byte{'d', 'a', 't', 'a'},
+		DataSize:      uint32(dataSize),
+	}
+	return binary.Write(w, binary.LittleEndian, &header)
+}
@@ -0,0 +1,97 @@
+// Package audio
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteWAV(t *testing.T) {
+	buf := &Buffer{Format: Format{SampleRate: 24000, Channels: 1}, Samples: []int16{1, -1, 256}}
+	var out bytes.Buffer
+	require.NoError(t, WriteWAV(&out, buf))
+
+	var header wavHeader
+	require.NoError(t, binary.Read(bytes.NewReader(out.Bytes()), binary.LittleEndian, &header))
+	assert.Equal(t, "RIFF", string(header.RIFF[:]))
+	assert.Equal(t, uint32(42), header.RIFFSize)
+	assert.Equal(t, "WAVE", string(header.WAVE[:]))
+	assert.Equal(t, "fmt ", string(header.Fmt[:]))
+	assert.Equal(t, uint16(1), header.AudioFormat)
+	assert.Equal(t, uint16(1), header.Channels)
+	assert.Equal(t, uint32(24000), header.SampleRate)
+	assert.Equal(t, uint32(48000), header.ByteRate)
+	assert.Equal(t, uint16(2), header.BlockAlign)
+	assert.Equal(t, uint16(16), header.BitsPerSample)
+	assert.Equal(t, "data", string(header.Data[:]))
+	assert.Equal(t, uint32(6), header.DataSize)
+	assert.Equal(t, []byte{0x01, 0x00, 0xff, 0xff, 0x00, 0x01}, out.Bytes()[wavHeaderSize:])
+
+	assert.Error(t, WriteWAV(&out, &Buffer{}))
+}
+
+func TestEncodeWAV(t *testing.T) {
+	data := readFixture(t)
+	buf, err := Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	var expected bytes.Buffer
+	require.NoError(t, WriteWAV(&expected, buf))
+
+	// Buffered in memory
+	d, err := NewDecoder(bytes.NewReader(data))
+	require.NoError(t, err)
+	var out bytes.Buffer
+	require.NoError(t, EncodeWAV(&out, d.Format(), d))
+	assert.Equal(t, expected.Bytes(), out.Bytes())
+
+	// Streamed to a file
+	path := filepath.Join(t.TempDir(), "speech.wav")
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	d, err = NewDecoder(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.NoError(t, EncodeWAV(file, d.Format(), d))
+	require.NoError(t, file.Close())
+	written, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, expected.Bytes(), written)
+	assert.Equal(t, wavHeaderSize+4*speechFrames, len(written))
+
+	// Pipes are files too, but cannot seek
+	pr, pw, err := os.Pipe()
+	require.NoError(t, err)
+	defer pr.Close()
+	piped := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(pr)
+		piped <- data
+	}()
+	d, err = NewDecoder(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.NoError(t, EncodeWAV(pw, d.Format(), d))
+	require.NoError(t, pw.Close())
+	assert.Equal(t, expected.Bytes(), <-piped)
+}
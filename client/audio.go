@@ -0,0 +1,324 @@
+// Package client
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AudioWaitStrategy selects how AudioInference waits for the backend to store the audio URL of a message.
+type AudioWaitStrategy int
+
+const (
+	// AudioWaitPoll re-reads the message with increasing intervals.
+	AudioWaitPoll AudioWaitStrategy = iota
+	// AudioWaitSnapshot listens for changes of the message document using a Firestore snapshot listener.
+	AudioWaitSnapshot
+)
+
+// AudioStage describes the progress of an audio inference.
+type AudioStage int
+
+const (
+	// AudioStageRequested is reported after the backend audio inference was invoked.
+	AudioStageRequested AudioStage = iota
+	// AudioStageWaiting is reported whenever the message was checked and does not have an audio URL yet.
+	AudioStageWaiting
+	// AudioStageReady is reported once the audio URL is available.
+	AudioStageReady
+)
+
+func (s AudioStage) String() string {
+	switch s {
+	case AudioStageRequested:
+		return "requested"
+	case AudioStageWaiting:
+		return "waiting"
+	case AudioStageReady:
+		return "ready"
+	default:
+		return fmt.Sprintf("AudioStage(%d)", int(s))
+	}
+}
+
+// AudioProgress is passed to AudioWaitOptions.Progress while waiting for audio.
+type AudioProgress struct {
+	MessageID string
+	Stage     AudioStage
+	// Attempt counts the checks of the message after the inference was requested.
+	Attempt int
+	// Elapsed is the time since the inference was requested.
+	Elapsed time.Duration
+}
+
+// AudioWaitOptions configures how AudioInference waits for the audio of a message.
+// Zero fields are set to their defaults.
+type AudioWaitOptions struct {
+	// Strategy selects polling or a snapshot listener. Defaults to AudioWaitPoll.
+	Strategy AudioWaitStrategy
+	// PollInterval is the delay before the first re-read when polling. Defaults to 500ms.
+	PollInterval time.Duration
+	// MaxPollInterval caps the delay between re-reads when polling. Defaults to 5s.
+	MaxPollInterval time.Duration
+	// Multiplier is the factor by which the poll interval grows after each re-read. Defaults to 1.5.
+	Multiplier float64
+	// MaxWait is the maximum time to wait for the audio URL. Defaults to 60s.
+	MaxWait time.Duration
+	// Progress is called with progress updates if set. It is called synchronously and should return quickly.
+	Progress func(AudioProgress)
+}
+
+// DefaultAudioWaitOptions returns the wait options used if the client has none configured.
+func DefaultAudioWaitOptions() *AudioWaitOptions {
+	return &AudioWaitOptions{
+		Strategy:        AudioWaitPoll,
+		PollInterval:    500 * time.Millisecond,
+		MaxPollInterval: 5 * time.Second,
+		Multiplier:      1.5,
+		MaxWait:         60 * time.Second,
+	}
+}
+
+// withDefaults returns a copy of the options with zero fields set to their defaults.
+func (o *AudioWaitOptions) withDefaults() AudioWaitOptions {
+	defaults := DefaultAudioWaitOptions()
+	if o == nil {
+		return *defaults
+	}
+	opts := *o
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaults.PollInterval
+	}
+	if opts.MaxPollInterval <= 0 {
+		opts.MaxPollInterval = max(defaults.MaxPollInterval, opts.PollInterval)
+	}
+	if opts.Multiplier < 1 {
+		opts.Multiplier = defaults.Multiplier
+	}
+	if opts.MaxWait <= 0 {
+		opts.MaxWait = defaults.MaxWait
+	}
+	return opts
+}
+
+// report calls the progress callback, if set.
+func (o *AudioWaitOptions) report(progress AudioProgress) {
+	if o.Progress != nil {
+		o.Progress(progress)
+	}
+}
+
+// resolveAudioMessage returns the message with its audio URL, generating the audio first if the message has none yet.
+func (k *KindroidAI) resolveAudioMessage(ctx context.Context, aiID, messageID string) (*ChatMessage, error) {
+	// Fetch the message for given ID and check for Audio URL
+	message, errMessage := k.GetMessageById(ctx, aiID, messageID)
+	if errMessage != nil {
+		return nil, fmt.Errorf("failed to fetch message for ID %s: %w", messageID, errMessage)
+	}
+	if message.Audio != "" {
+		return message, nil
+	}
+	return k.generateAudio(ctx, aiID, messageID)
+}
+
+// generateAudio invokes the backend audio inference for a message and waits for the audio URL
+// according to the client's AudioWait options.
+func (k *KindroidAI) generateAudio(ctx context.Context, aiID, messageID string) (*ChatMessage, error) {
+	opts := k.AudioWait.withDefaults()
+	start := time.Now()
+	if errInference := k.invokeBackendAudioInference(ctx, aiID, messageID); errInference != nil {
+		return nil, fmt.Errorf("failed to invoke backend audio inference API: %w", errInference)
+	}
+	opts.report(AudioProgress{MessageID: messageID, Stage: AudioStageRequested})
+
+	waitCtx, cancel := context.WithTimeout(ctx, opts.MaxWait)
+	defer cancel()
+	var message *ChatMessage
+	var errMessage error
+	if opts.Strategy == AudioWaitSnapshot {
+		message, errMessage = k.waitForAudioSnapshot(waitCtx, aiID, messageID, start, &opts)
+	} else {
+		message, errMessage = k.pollForAudio(waitCtx, aiID, messageID, start, &opts)
+	}
+	if errMessage != nil {
+		if waitCtx.Err() != nil {
+			return nil, fmt.Errorf("%w for message ID %s after %s: %w",
+				ErrAudioNotReady, messageID, time.Since(start).Round(time.Millisecond), waitCtx.Err())
+		}
+		return nil, errMessage
+	}
+	opts.report(AudioProgress{MessageID: messageID, Stage: AudioStageReady, Elapsed: time.Since(start)})
+	return message, nil
+}
+
+// pollForAudio re-reads the message until it has an audio URL or the context ends.
+func (k *KindroidAI) pollForAudio(ctx context.Context, aiID, messageID string, start time.Time, opts *AudioWaitOptions) (*ChatMessage, error) {
+	delay := opts.PollInterval
+	for attempt := 1; ; attempt++ {
+		message, err := k.GetMessageById(ctx, aiID, messageID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch message for ID %s after invoking audio inference: %w", messageID, err)
+		}
+		if message.Audio != "" {
+			return message, nil
+		}
+		opts.report(AudioProgress{MessageID: messageID, Stage: AudioStageWaiting, Attempt: attempt, Elapsed: time.Since(start)})
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		delay = min(time.Duration(float64(delay)*opts.Multiplier), opts.MaxPollInterval)
+	}
+}
+
+// waitForAudioSnapshot listens for changes of the message document until it has an audio URL or the context ends.
+func (k *KindroidAI) waitForAudioSnapshot(ctx context.Context, aiID, messageID string, start time.Time, opts *AudioWaitOptions) (*ChatMessage, error) {
+	creds, err := k.checkFirestoreAccess(ctx, "waiting for audio")
+	if err != nil {
+		return nil, err
+	}
+	client, release, err := k.firestoreClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	snapshots := chatMessagesCollection(client, creds.userID, aiID).Doc(messageID).Snapshots(ctx)
+	defer snapshots.Stop()
+
+	for attempt := 1; ; attempt++ {
+		snapshot, err := snapshots.Next()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("failed to listen for message ID %s: %w", messageID, err)
+		}
+		if !snapshot.Exists() {
+			return nil, fmt.Errorf("message ID %s was deleted while waiting for audio", messageID)
+		}
+		message, err := k.messageFromFirebaseDocument(snapshot, creds.userID)
+		if err != nil {
+			return nil, err
+		}
+		if message.Audio != "" {
+			return message, nil
+		}
+		opts.report(AudioProgress{MessageID: messageID, Stage: AudioStageWaiting, Attempt: attempt, Elapsed: time.Since(start)})
+	}
+}
+
+// AudioInfo describes the audio returned by AudioStream.
+type AudioInfo struct {
+	MessageID string
+	// URL is the decrypted audio URL of the message.
+	URL string
+	// ContentType is the MIME type of the audio without parameters, e.g. "audio/mpeg".
+	ContentType string
+	// ContentLength is the size of the audio in bytes, or -1 if unknown.
+	ContentLength int64
+}
+
+// AudioStream returns the audio of the given message as a stream, generating it first if necessary (see AudioInference).
+// The audio is downloaded using the client's HTTP client and is not buffered in memory, so it can be piped
+// straight to a player or network connection. The caller must close the returned stream.
+// If an AudioCache is configured, cached audio is returned without downloading it, and downloaded audio
+// is stored in the cache once it was read completely.
+// ErrUnexpectedContentType is returned if the server does not respond with audio.
+func (k *KindroidAI) AudioStream(ctx context.Context, messageID string) (io.ReadCloser, AudioInfo, error) {
+	return k.AudioStreamAI(ctx, k.KindroidID, messageID)
+}
+
+// AudioStreamAI is like AudioStream but returns the audio of a message of the given AI instead of the client's default AI.
+func (k *KindroidAI) AudioStreamAI(ctx context.Context, aiID, messageID string) (io.ReadCloser, AudioInfo, error) {
+	if _, err := k.jwtCredentials(ctx, "audio inference"); err != nil {
+		return nil, AudioInfo{}, err
+	}
+
+	message, err := k.resolveAudioMessage(ctx, aiID, messageID)
+	if err != nil {
+		return nil, AudioInfo{}, err
+	}
+	if cached, info := k.cachedAudio(aiID, message); cached != nil {
+		return cached, info, nil
+	}
+	body, info, err := k.downloadAudio(ctx, messageID, message.Audio)
+	if err != nil {
+		return nil, info, err
+	}
+	return k.cacheAudio(aiID, message, body), info, nil
+}
+
+// downloadAudio starts the download of the given audio URL.
+// The API key is not sent, since the URL points to a storage host rather than the Kindroid API.
+func (k *KindroidAI) downloadAudio(ctx context.Context, messageID, audioURL string) (io.ReadCloser, AudioInfo, error) {
+	info := AudioInfo{MessageID: messageID, URL: audioURL, ContentLength: -1}
+	req, err := http.NewRequestWithContext(ctx, "GET", audioURL, nil)
+	if err != nil {
+		return nil, info, fmt.Errorf("invalid audio URL for message ID %s: %w", messageID, err)
+	}
+	if k.UserAgent != "" {
+		req.Header.Set("User-Agent", k.UserAgent)
+	}
+
+	resp, err := k.Client.Do(req)
+	if err != nil {
+		return nil, info, fmt.Errorf("failed to download audio for message ID %s: %w", messageID, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, info, fmt.Errorf("failed to download audio for message ID %s: %w", messageID, newAPIError(resp, "audio"))
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if mediaType, _, errType := mime.ParseMediaType(contentType); errType == nil {
+		contentType = mediaType
+	}
+	if !isAudioContentType(contentType) {
+		resp.Body.Close()
+		return nil, info, fmt.Errorf("%w for message ID %s: %q", ErrUnexpectedContentType, messageID, contentType)
+	}
+	if contentType == "" || !strings.HasPrefix(contentType, "audio/") {
+		// Storage hosts may not know the type of the file; Kindroid audio is always MP3
+		contentType = "audio/mpeg"
+	}
+	info.ContentType = contentType
+	info.ContentLength = resp.ContentLength
+	return resp.Body, info, nil
+}
+
+// isAudioContentType reports whether a download with the given media type may contain audio.
+// Generic binary types are accepted, since storage hosts do not always know the file type.
+func isAudioContentType(mediaType string) bool {
+	switch {
+	case strings.HasPrefix(mediaType, "audio/"):
+		return true
+	case mediaType == "", mediaType == "application/octet-stream", mediaType == "binary/octet-stream":
+		return true
+	default:
+		return false
+	}
+}
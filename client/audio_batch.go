@@ -0,0 +1,154 @@
+// Package client
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// DefaultAudioBatchConcurrency is the number of messages GenerateAudioBatch processes in parallel by default.
+const DefaultAudioBatchConcurrency = 4
+
+// AudioBatchOptions configures GenerateAudioBatch.
+type AudioBatchOptions struct {
+	// Concurrency is the maximum number of messages processed in parallel. Defaults to DefaultAudioBatchConcurrency.
+	Concurrency int
+	// RateLimit is the maximum number of audio inference requests per second. Zero means unlimited.
+	RateLimit float64
+	// Progress is called after each message was processed. Calls are serialized, so it needs no locking,
+	// but it should return quickly.
+	Progress func(AudioBatchProgress)
+}
+
+// AudioBatchResult is the outcome of generating the audio of a single message.
+type AudioBatchResult struct {
+	MessageID string
+	// Message is the message with its audio URL if the audio is available.
+	Message *ChatMessage
+	// Skipped is true if the message already had audio, so no inference was requested.
+	Skipped bool
+	// Err is set if the audio could not be generated.
+	Err error
+}
+
+// AudioBatchProgress is passed to AudioBatchOptions.Progress.
+type AudioBatchProgress struct {
+	// Result is the result of the message processed last.
+	Result AudioBatchResult
+	// Completed is the number of processed messages, including the one in Result.
+	Completed int
+	Total     int
+	// Elapsed is the time since the batch was started.
+	Elapsed time.Duration
+}
+
+// GenerateAudioBatch generates the audio of the given messages of an AI, e.g. to export a whole conversation
+// with voice. Messages which already have audio are skipped. The messages are processed in parallel, and
+// inference requests are rate limited according to opts; each request waits for its audio like AudioInference.
+//
+// Results are returned in the order of messageIDs, with per-message errors in AudioBatchResult.Err.
+// The returned error is only set if the batch could not be started, or if the context ended, in which case
+// unprocessed messages have the context error as their result.
+//
+// WARNING: This method uses the same undocumented API endpoint as AudioInference.
+func (k *KindroidAI) GenerateAudioBatch(ctx context.Context, aiID string, messageIDs []string, opts AudioBatchOptions) ([]AudioBatchResult, error) {
+	if _, err := k.jwtCredentials(ctx, "audio inference"); err != nil {
+		return nil, err
+	}
+	if aiID == "" {
+		aiID = k.KindroidID
+	}
+	if opts.Concurrency < 0 || opts.RateLimit < 0 {
+		return nil, errors.New("audio batch concurrency and rate limit must not be negative")
+	}
+	concurrency := opts.Concurrency
+	if concurrency == 0 {
+		concurrency = DefaultAudioBatchConcurrency
+	}
+	limiter := rate.NewLimiter(rate.Inf, 0)
+	if opts.RateLimit > 0 {
+		limiter = rate.NewLimiter(rate.Limit(opts.RateLimit), 1)
+	}
+
+	start := time.Now()
+	results := make([]AudioBatchResult, len(messageIDs))
+	var mu sync.Mutex
+	completed := 0
+	finish := func(i int, result AudioBatchResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		results[i] = result
+		completed++
+		if opts.Progress != nil {
+			opts.Progress(AudioBatchProgress{Result: result, Completed: completed, Total: len(messageIDs), Elapsed: time.Since(start)})
+		}
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range min(concurrency, len(messageIDs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				finish(i, k.generateBatchAudio(ctx, aiID, messageIDs[i], limiter))
+			}
+		}()
+	}
+	next := 0
+feed:
+	for ; next < len(messageIDs); next++ {
+		select {
+		case indexes <- next:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	for i := next; i < len(messageIDs); i++ {
+		finish(i, AudioBatchResult{MessageID: messageIDs[i], Err: ctx.Err()})
+	}
+	return results, ctx.Err()
+}
+
+// generateBatchAudio generates the audio of a single message of a batch.
+func (k *KindroidAI) generateBatchAudio(ctx context.Context, aiID, messageID string, limiter *rate.Limiter) AudioBatchResult {
+	result := AudioBatchResult{MessageID: messageID}
+	message, err := k.GetMessageById(ctx, aiID, messageID)
+	if err != nil {
+		result.Err = fmt.Errorf("failed to fetch message for ID %s: %w", messageID, err)
+		return result
+	}
+	if message.Audio != "" {
+		result.Message, result.Skipped = message, true
+		return result
+	}
+	if err = limiter.Wait(ctx); err != nil {
+		result.Err = err
+		return result
+	}
+	result.Message, result.Err = k.generateAudio(ctx, aiID, messageID)
+	return result
+}
@@ -0,0 +1,346 @@
+// Package client
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package client
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrAudioCacheMiss is returned by AudioCache.Get if the audio is not cached.
+var ErrAudioCacheMiss = errors.New("audio not cached")
+
+// AudioCacheKey identifies cached audio.
+// The URL is part of the key, so audio that was generated again is not served from an outdated entry.
+type AudioCacheKey struct {
+	AIID      string
+	MessageID string
+	// URL is the decrypted audio URL of the message.
+	URL string
+}
+
+// hash returns the content address of the key.
+func (key AudioCacheKey) hash() string {
+	sum := sha256.Sum256([]byte(key.AIID + "\x00" + key.MessageID + "\x00" + key.URL))
+	return hex.EncodeToString(sum[:])
+}
+
+// AudioCache stores downloaded audio, so replaying a message does not download it again.
+// Implementations must be safe for concurrent use.
+type AudioCache interface {
+	// Get returns the cached audio and its size, or ErrAudioCacheMiss if it is not cached.
+	Get(key AudioCacheKey) (io.ReadCloser, int64, error)
+	// Put stores the audio read from r. Nothing is stored if reading r fails.
+	Put(key AudioCacheKey, r io.Reader) error
+}
+
+// audioCacheExt is the file extension of cached audio files; Kindroid audio is always MP3.
+const audioCacheExt = ".mp3"
+
+// FileAudioCache is an AudioCache storing audio files in a directory.
+// If a size limit is set, the least recently used files are evicted once the limit is exceeded.
+// Files are written atomically, so concurrent readers and crashed writers never expose partial audio.
+type FileAudioCache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	lru     *list.List // of *fileCacheEntry, most recently used first
+	entries map[string]*list.Element
+	size    int64
+}
+
+type fileCacheEntry struct {
+	name string
+	size int64
+}
+
+// NewFileAudioCache creates a cache in the given directory, creating it if necessary.
+// Files already present from an earlier run are reused, ordered by their modification time.
+// maxBytes limits the total size of the cache; zero or a negative value means unlimited.
+func NewFileAudioCache(dir string, maxBytes int64) (*FileAudioCache, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create audio cache directory: %w", err)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audio cache directory: %w", err)
+	}
+
+	c := &FileAudioCache{dir: dir, maxBytes: maxBytes, lru: list.New(), entries: make(map[string]*list.Element)}
+	type existingFile struct {
+		entry   *fileCacheEntry
+		modTime time.Time
+	}
+	var existing []existingFile
+	for _, file := range files {
+		name := file.Name()
+		if strings.HasPrefix(name, ".tmp-") {
+			// Left over by an interrupted write
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		if file.IsDir() || !strings.HasSuffix(name, audioCacheExt) {
+			continue
+		}
+		info, errInfo := file.Info()
+		if errInfo != nil {
+			continue
+		}
+		existing = append(existing, existingFile{&fileCacheEntry{name: name, size: info.Size()}, info.ModTime()})
+	}
+	sort.Slice(existing, func(i, j int) bool { return existing[i].modTime.After(existing[j].modTime) })
+	for _, file := range existing {
+		c.entries[file.entry.name] = c.lru.PushBack(file.entry)
+		c.size += file.entry.size
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evict()
+	return c, nil
+}
+
+// Get implements AudioCache.
+func (c *FileAudioCache) Get(key AudioCacheKey) (io.ReadCloser, int64, error) {
+	name := key.hash() + audioCacheExt
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[name]
+	if !ok {
+		return nil, 0, ErrAudioCacheMiss
+	}
+	path := filepath.Join(c.dir, name)
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		// Removed by someone else
+		c.remove(element)
+		return nil, 0, ErrAudioCacheMiss
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open cached audio: %w", err)
+	}
+
+	c.lru.MoveToFront(element)
+	// Persist the access time, so the LRU order survives restarts
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+	return file, element.Value.(*fileCacheEntry).size, nil
+}
+
+// Put implements AudioCache.
+func (c *FileAudioCache) Put(key AudioCacheKey, r io.Reader) error {
+	name := key.hash() + audioCacheExt
+	tmp, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create cache file: %w", err)
+	}
+	size, err := io.Copy(tmp, r)
+	if errClose := tmp.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+	if c.maxBytes > 0 && size > c.maxBytes {
+		// Would evict everything else and itself
+		os.Remove(tmp.Name())
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err = os.Rename(tmp.Name(), filepath.Join(c.dir, name)); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to store cache file: %w", err)
+	}
+	if element, ok := c.entries[name]; ok {
+		entry := element.Value.(*fileCacheEntry)
+		c.size += size - entry.size
+		entry.size = size
+		c.lru.MoveToFront(element)
+	} else {
+		c.entries[name] = c.lru.PushFront(&fileCacheEntry{name: name, size: size})
+		c.size += size
+	}
+	c.evict()
+	return nil
+}
+
+// Size returns the total size of the cached audio in bytes.
+func (c *FileAudioCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// Len returns the number of cached audio files.
+func (c *FileAudioCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// evict removes the least recently used files until the cache fits its size limit. The caller must hold c.mu.
+func (c *FileAudioCache) evict() {
+	for c.maxBytes > 0 && c.size > c.maxBytes && c.lru.Len() > 0 {
+		element := c.lru.Back()
+		os.Remove(filepath.Join(c.dir, element.Value.(*fileCacheEntry).name))
+		c.remove(element)
+	}
+}
+
+// remove drops an entry from the index. The caller must hold c.mu.
+func (c *FileAudioCache) remove(element *list.Element) {
+	entry := element.Value.(*fileCacheEntry)
+	c.lru.Remove(element)
+	delete(c.entries, entry.name)
+	c.size -= entry.size
+}
+
+// audioCacheKey returns the cache key of the given message of an AI.
+func audioCacheKey(aiID string, message *ChatMessage) AudioCacheKey {
+	return AudioCacheKey{AIID: aiID, MessageID: message.ID, URL: message.Audio}
+}
+
+// cachedAudio returns the cached audio of the message, or nil if the client has no cache or the audio is not cached.
+func (k *KindroidAI) cachedAudio(aiID string, message *ChatMessage) (io.ReadCloser, AudioInfo) {
+	info := AudioInfo{MessageID: message.ID, URL: message.Audio, ContentType: "audio/mpeg", ContentLength: -1}
+	if k.AudioCache == nil || message.Audio == "" {
+		return nil, info
+	}
+	reader, size, err := k.AudioCache.Get(audioCacheKey(aiID, message))
+	if err != nil {
+		if !errors.Is(err, ErrAudioCacheMiss) {
+			k.logger().Warn("failed to read cached audio", "message_id", message.ID, "error", err)
+		}
+		return nil, info
+	}
+	info.ContentLength = size
+	return reader, info
+}
+
+// cacheAudio wraps the downloaded audio, so it is stored in the client's cache while it is being read.
+// The audio is only stored if it was read completely.
+func (k *KindroidAI) cacheAudio(aiID string, message *ChatMessage, body io.ReadCloser) io.ReadCloser {
+	if k.AudioCache == nil {
+		return body
+	}
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := k.AudioCache.Put(audioCacheKey(aiID, message), pr); err != nil && !errors.Is(err, errAudioIncomplete) {
+			k.logger().Warn("failed to cache audio", "message_id", message.ID, "error", err)
+		}
+		// Unblock the writer if Put returned early
+		pr.CloseWithError(errAudioIncomplete)
+	}()
+	return &cachingReader{body: body, pipe: pw, done: done}
+}
+
+// errAudioIncomplete aborts caching of audio which was not read completely.
+var errAudioIncomplete = errors.New("audio was not read completely")
+
+// cachingReader copies everything read from the body into the cache.
+type cachingReader struct {
+	body     io.ReadCloser
+	pipe     *io.PipeWriter
+	done     chan struct{}
+	complete bool
+	closed   bool
+}
+
+func (r *cachingReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	if n > 0 {
+		// Caching is best effort; a failing cache must not break playback
+		_, _ = r.pipe.Write(p[:n])
+	}
+	if err == io.EOF {
+		r.complete = true
+	}
+	return n, err
+}
+
+// Close closes the body and waits until the audio is stored in the cache.
+func (r *cachingReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	err := r.body.Close()
+	if r.complete {
+		r.pipe.Close()
+	} else {
+		r.pipe.CloseWithError(errAudioIncomplete)
+	}
+	<-r.done
+	return err
+}
+
+// WarmAudioCache stores the audio of the given AI messages in the client's AudioCache.
+// Audio which is not generated yet is generated first (see AudioInference); messages of the user are skipped.
+// All messages are processed, and the errors of failed messages are returned joined.
+func (k *KindroidAI) WarmAudioCache(ctx context.Context, messages []*ChatMessage) error {
+	if k.AudioCache == nil {
+		return errors.New("no audio cache configured")
+	}
+	var errs []error
+	for _, message := range messages {
+		if message.Sender != SenderAI {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		var stream io.ReadCloser
+		var err error
+		if message.Audio == "" {
+			stream, _, err = k.AudioStream(ctx, message.ID)
+		} else if cached, _ := k.cachedAudio(k.KindroidID, message); cached != nil {
+			cached.Close()
+			continue
+		} else if stream, _, err = k.downloadAudio(ctx, message.ID, message.Audio); err == nil {
+			stream = k.cacheAudio(k.KindroidID, message, stream)
+		}
+		if err == nil {
+			_, err = io.Copy(io.Discard, stream)
+			if errClose := stream.Close(); err == nil {
+				err = errClose
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("message ID %s: %w", message.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
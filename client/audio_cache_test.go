@@ -0,0 +1,100 @@
+// Package client
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readCached(t *testing.T, cache AudioCache, key AudioCacheKey) string {
+	t.Helper()
+	reader, size, err := cache.Get(key)
+	require.NoError(t, err)
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), size)
+	return string(data)
+}
+
+func TestFileAudioCache(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewFileAudioCache(dir, 25)
+	require.NoError(t, err)
+
+	key := func(id string) AudioCacheKey {
+		return AudioCacheKey{AIID: testAIID, MessageID: id, URL: "https://example.com/" + id + ".mp3"}
+	}
+
+	_, _, err = cache.Get(key("a"))
+	assert.ErrorIs(t, err, ErrAudioCacheMiss)
+
+	require.NoError(t, cache.Put(key("a"), strings.NewReader("aaaaaaaaaa")))
+	require.NoError(t, cache.Put(key("b"), strings.NewReader("bbbbbbbbbb")))
+	assert.Equal(t, "aaaaaaaaaa", readCached(t, cache, key("a")))
+
+	// Audio generated again has a different URL and is not served from the old entry
+	regenerated := key("a")
+	regenerated.URL = "https://example.com/a-v2.mp3"
+	_, _, err = cache.Get(regenerated)
+	assert.ErrorIs(t, err, ErrAudioCacheMiss)
+
+	// Exceeding the limit evicts the least recently used entry, which is "b" since "a" was read
+	require.NoError(t, cache.Put(key("c"), strings.NewReader("cccccccccc")))
+	assert.Equal(t, 2, cache.Len())
+	assert.Equal(t, int64(20), cache.Size())
+	_, _, err = cache.Get(key("b"))
+	assert.ErrorIs(t, err, ErrAudioCacheMiss)
+	assert.Equal(t, "cccccccccc", readCached(t, cache, key("c")))
+
+	// Entries larger than the limit are not stored
+	require.NoError(t, cache.Put(key("huge"), strings.NewReader(strings.Repeat("x", 30))))
+	_, _, err = cache.Get(key("huge"))
+	assert.ErrorIs(t, err, ErrAudioCacheMiss)
+	assert.Equal(t, 2, cache.Len())
+
+	// Failed writes leave nothing behind
+	err = cache.Put(key("broken"), iotest.ErrReader(errors.New("connection reset")))
+	assert.Error(t, err)
+	_, _, err = cache.Get(key("broken"))
+	assert.ErrorIs(t, err, ErrAudioCacheMiss)
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	// The cache is restored from the directory; left over temporary files are removed
+	require.NoError(t, os.WriteFile(dir+"/.tmp-123", []byte("partial"), 0o600))
+	restored, err := NewFileAudioCache(dir, 25)
+	require.NoError(t, err)
+	assert.Equal(t, 2, restored.Len())
+	assert.Equal(t, "aaaaaaaaaa", readCached(t, restored, key("a")))
+	assert.NoFileExists(t, dir+"/.tmp-123")
+
+	// A smaller limit evicts entries on startup
+	shrunk, err := NewFileAudioCache(dir, 15)
+	require.NoError(t, err)
+	assert.Equal(t, 1, shrunk.Len())
+}
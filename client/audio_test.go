@@ -0,0 +1,377 @@
+// Package client
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/internal/testutil"
+	"github.com/stretchr/testify/suite"
+)
+
+// roundTripperFunc adapts a function to http.RoundTripper.
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+type AudioTestSuite struct {
+	suite.Suite
+	Firestore *testutil.Firestore
+	Server    *httptest.Server
+	// AudioDelay is how long the fake backend takes to store the audio URL. Zero means never.
+	AudioDelay atomic.Int64
+	Requests   atomic.Int32
+	Downloads  atomic.Int32
+
+	mu       sync.Mutex
+	progress []AudioProgress
+}
+
+func (suite *AudioTestSuite) SetupTest() {
+	suite.Firestore = testutil.NewFirestore(suite.T(), DefaultFirestoreProject)
+	suite.AudioDelay.Store(int64(300 * time.Millisecond))
+	suite.Requests.Store(0)
+	suite.Downloads.Store(0)
+	suite.progress = nil
+
+	suite.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/audio-inference":
+			suite.Requests.Add(1)
+			var request AudioInferenceRequest
+			suite.NoError(json.NewDecoder(r.Body).Decode(&request))
+			suite.Equal(testAIID, request.AIID)
+			if delay := time.Duration(suite.AudioDelay.Load()); delay > 0 {
+				time.AfterFunc(delay, func() {
+					suite.putAudioMessage(request.MessageID, suite.Server.URL+"/audio.mp3")
+				})
+			}
+		case "/audio.mp3":
+			suite.Downloads.Add(1)
+			suite.Equal("", r.Header.Get("Authorization"), "the API key must not be sent to the storage host")
+			w.Header().Set("Content-Type", "audio/mpeg")
+			w.Write([]byte("ID3-fake-mpeg-data"))
+		case "/octet-stream":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte("ID3-fake-mpeg-data"))
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte("<html>Access denied</html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	suite.T().Cleanup(suite.Server.Close)
+	suite.putMessage("")
+}
+
+func (suite *AudioTestSuite) putMessage(audio string) {
+	suite.putAudioMessage("reply", audio)
+}
+
+func (suite *AudioTestSuite) putAudioMessage(id, audio string) {
+	suite.Firestore.PutMessage(suite.T(), testUserID, testAIID, testutil.Message{
+		ID: id, Message: "Hello, user!", Sender: SenderAI, Timestamp: 1000, Audio: audio,
+	})
+}
+
+// newClient creates a client using the given wait options, recording progress updates.
+func (suite *AudioTestSuite) newClient(opts AudioWaitOptions, extra ...Option) *KindroidAI {
+	opts.Progress = func(progress AudioProgress) {
+		suite.mu.Lock()
+		defer suite.mu.Unlock()
+		suite.progress = append(suite.progress, progress)
+	}
+	k, err := New(testutil.JWT(testUserID), append([]Option{
+		WithKindroidID(testAIID),
+		WithBaseURL(suite.Server.URL),
+		WithFirestoreEmulator(suite.Firestore.Addr),
+		WithAudioWait(opts),
+	}, extra...)...)
+	suite.Require().NoError(err)
+	suite.T().Cleanup(func() { k.Close() })
+	return k
+}
+
+func (suite *AudioTestSuite) stages() []AudioStage {
+	suite.mu.Lock()
+	defer suite.mu.Unlock()
+	var stages []AudioStage
+	for _, progress := range suite.progress {
+		if len(stages) == 0 || stages[len(stages)-1] != progress.Stage {
+			stages = append(stages, progress.Stage)
+		}
+	}
+	return stages
+}
+
+func (suite *AudioTestSuite) TestPolling() {
+	k := suite.newClient(AudioWaitOptions{PollInterval: 20 * time.Millisecond, MaxPollInterval: 50 * time.Millisecond})
+
+	audio, err := k.AudioInference("reply")
+	suite.Require().NoError(err)
+	suite.Equal("ID3-fake-mpeg-data", string(audio))
+	suite.Equal(int32(1), suite.Requests.Load())
+	suite.Equal([]AudioStage{AudioStageRequested, AudioStageWaiting, AudioStageReady}, suite.stages())
+
+	suite.mu.Lock()
+	defer suite.mu.Unlock()
+	last := suite.progress[len(suite.progress)-1]
+	suite.Equal("reply", last.MessageID)
+	suite.GreaterOrEqual(last.Elapsed, 300*time.Millisecond)
+	// Backoff: 20ms, 30ms, 45ms, then capped at 50ms
+	suite.Less(len(suite.progress), 12, "polling should back off")
+
+	// Audio which is already available is returned without invoking the backend again
+	audio, err = k.AudioInference("reply")
+	suite.Require().NoError(err)
+	suite.Equal("ID3-fake-mpeg-data", string(audio))
+	suite.Equal(int32(1), suite.Requests.Load())
+}
+
+func (suite *AudioTestSuite) TestSnapshot() {
+	k := suite.newClient(AudioWaitOptions{Strategy: AudioWaitSnapshot})
+
+	audio, err := k.AudioInference("reply")
+	suite.Require().NoError(err)
+	suite.Equal("ID3-fake-mpeg-data", string(audio))
+	suite.Equal([]AudioStage{AudioStageRequested, AudioStageWaiting, AudioStageReady}, suite.stages())
+}
+
+func (suite *AudioTestSuite) TestTimeout() {
+	suite.AudioDelay.Store(0)
+	for _, strategy := range []AudioWaitStrategy{AudioWaitPoll, AudioWaitSnapshot} {
+		k := suite.newClient(AudioWaitOptions{Strategy: strategy, PollInterval: 20 * time.Millisecond, MaxWait: 200 * time.Millisecond})
+		start := time.Now()
+		_, err := k.AudioInference("reply")
+		suite.ErrorIs(err, ErrAudioNotReady)
+		suite.ErrorIs(err, context.DeadlineExceeded)
+		suite.Less(time.Since(start), 2*time.Second)
+	}
+}
+
+func (suite *AudioTestSuite) TestCancellation() {
+	suite.AudioDelay.Store(0)
+	k := suite.newClient(AudioWaitOptions{PollInterval: 20 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	_, err := k.AudioInferenceContext(ctx, "reply")
+	suite.ErrorIs(err, context.Canceled)
+}
+
+func (suite *AudioTestSuite) TestAudioStream() {
+	var requests atomic.Int32
+	k := suite.newClient(AudioWaitOptions{PollInterval: 20 * time.Millisecond})
+	transport := k.Client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	k.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		requests.Add(1)
+		return transport.RoundTrip(req)
+	})
+
+	stream, info, err := k.AudioStream(context.Background(), "reply")
+	suite.Require().NoError(err)
+	defer stream.Close()
+	data, err := io.ReadAll(stream)
+	suite.Require().NoError(err)
+	suite.Equal("ID3-fake-mpeg-data", string(data))
+	suite.Equal(AudioInfo{
+		MessageID:     "reply",
+		URL:           suite.Server.URL + "/audio.mp3",
+		ContentType:   "audio/mpeg",
+		ContentLength: int64(len(data)),
+	}, info)
+	suite.Equal(int32(2), requests.Load(), "inference and download must use the configured HTTP client")
+
+	// Generic binary content is assumed to be MP3
+	suite.putAudioMessage("binary", suite.Server.URL+"/octet-stream")
+	stream, info, err = k.AudioStream(context.Background(), "binary")
+	suite.Require().NoError(err)
+	stream.Close()
+	suite.Equal("audio/mpeg", info.ContentType)
+
+	suite.putAudioMessage("html", suite.Server.URL+"/page.html")
+	_, _, err = k.AudioStream(context.Background(), "html")
+	suite.ErrorIs(err, ErrUnexpectedContentType)
+
+	suite.putAudioMessage("missing", suite.Server.URL+"/missing.mp3")
+	_, _, err = k.AudioStream(context.Background(), "missing")
+	var apiErr *APIError
+	suite.Require().ErrorAs(err, &apiErr)
+	suite.Equal(http.StatusNotFound, apiErr.StatusCode)
+
+	// Messages of other AIs are read from their own chat
+	suite.Firestore.PutMessage(suite.T(), testUserID, "other_ai_id", testutil.Message{
+		ID: "other", Message: "Hi!", Sender: SenderAI, Timestamp: 1000, Audio: suite.Server.URL + "/audio.mp3",
+	})
+	stream, info, err = k.AudioStreamAI(context.Background(), "other_ai_id", "other")
+	suite.Require().NoError(err)
+	stream.Close()
+	suite.Equal("other", info.MessageID)
+	_, _, err = k.AudioStream(context.Background(), "other")
+	suite.Error(err)
+
+	_, _, err = NewKindroidAI("test_api_key", testAIID).AudioStream(context.Background(), "reply")
+	suite.ErrorIs(err, ErrJWTRequired)
+}
+
+func (suite *AudioTestSuite) TestAudioCache() {
+	cache, err := NewFileAudioCache(suite.T().TempDir(), 0)
+	suite.Require().NoError(err)
+	k := suite.newClient(AudioWaitOptions{PollInterval: 20 * time.Millisecond}, WithAudioCache(cache))
+
+	// Streams which are not read completely are not cached
+	stream, _, err := k.AudioStream(context.Background(), "reply")
+	suite.Require().NoError(err)
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(stream, buf)
+	suite.Require().NoError(err)
+	suite.Require().NoError(stream.Close())
+	suite.Equal(0, cache.Len())
+
+	audio, err := k.AudioInference("reply")
+	suite.Require().NoError(err)
+	suite.Equal("ID3-fake-mpeg-data", string(audio))
+	suite.Equal(1, cache.Len())
+	suite.Equal(int32(2), suite.Downloads.Load())
+
+	// Replays are served from the cache
+	stream, info, err := k.AudioStream(context.Background(), "reply")
+	suite.Require().NoError(err)
+	data, err := io.ReadAll(stream)
+	suite.Require().NoError(err)
+	stream.Close()
+	suite.Equal("ID3-fake-mpeg-data", string(data))
+	suite.Equal(int64(len(data)), info.ContentLength)
+	suite.Equal("audio/mpeg", info.ContentType)
+	suite.Equal(int32(2), suite.Downloads.Load())
+	suite.Equal(int32(1), suite.Requests.Load())
+}
+
+func (suite *AudioTestSuite) TestWarmAudioCache() {
+	cache, err := NewFileAudioCache(suite.T().TempDir(), 0)
+	suite.Require().NoError(err)
+	k := suite.newClient(AudioWaitOptions{PollInterval: 20 * time.Millisecond}, WithAudioCache(cache))
+
+	suite.putAudioMessage("with_audio", suite.Server.URL+"/audio.mp3")
+	messages := []*ChatMessage{
+		{ID: "reply", Sender: SenderAI},
+		{ID: "with_audio", Sender: SenderAI, Audio: suite.Server.URL + "/audio.mp3"},
+		{ID: "question", Sender: SenderUser},
+		{ID: "broken", Sender: SenderAI, Audio: suite.Server.URL + "/page.html"},
+	}
+	err = k.WarmAudioCache(context.Background(), messages)
+	suite.ErrorIs(err, ErrUnexpectedContentType)
+	suite.ErrorContains(err, "broken")
+	suite.Equal(2, cache.Len())
+	suite.Equal(int32(1), suite.Requests.Load(), "missing audio is generated")
+
+	// Warming again does not download anything
+	downloads := suite.Downloads.Load()
+	suite.Require().NoError(k.WarmAudioCache(context.Background(), messages[:3]))
+	suite.Equal(downloads, suite.Downloads.Load())
+
+	suite.Error(NewKindroidAI(testutil.JWT(testUserID), testAIID).WarmAudioCache(context.Background(), messages))
+}
+
+func (suite *AudioTestSuite) TestGenerateAudioBatch() {
+	k := suite.newClient(AudioWaitOptions{PollInterval: 20 * time.Millisecond, MaxPollInterval: 20 * time.Millisecond})
+	for _, id := range []string{"m1", "m2", "m3", "m4"} {
+		suite.putAudioMessage(id, "")
+	}
+	suite.putAudioMessage("done", suite.Server.URL+"/done.mp3")
+
+	var progress []AudioBatchProgress
+	start := time.Now()
+	results, err := k.GenerateAudioBatch(context.Background(), testAIID, []string{"m1", "m2", "done", "missing", "m3", "m4"},
+		AudioBatchOptions{Concurrency: 4, Progress: func(p AudioBatchProgress) { progress = append(progress, p) }})
+	suite.Require().NoError(err)
+	// Generating the audio takes 300ms per message, so the messages must have been processed in parallel
+	suite.Less(time.Since(start), time.Second)
+
+	suite.Require().Len(results, 6)
+	for i, id := range []string{"m1", "m2", "done", "missing", "m3", "m4"} {
+		suite.Equal(id, results[i].MessageID)
+	}
+	suite.True(results[2].Skipped)
+	suite.Equal(suite.Server.URL+"/done.mp3", results[2].Message.Audio)
+	suite.ErrorContains(results[3].Err, "missing")
+	for _, i := range []int{0, 1, 4, 5} {
+		suite.Require().NoError(results[i].Err)
+		suite.False(results[i].Skipped)
+		suite.Equal(suite.Server.URL+"/audio.mp3", results[i].Message.Audio)
+	}
+	suite.Equal(int32(4), suite.Requests.Load())
+	suite.Require().Len(progress, 6)
+	suite.Equal(6, progress[5].Completed)
+	suite.Equal(6, progress[5].Total)
+
+	// Inference requests are rate limited
+	suite.AudioDelay.Store(int64(10 * time.Millisecond))
+	for _, id := range []string{"r1", "r2", "r3"} {
+		suite.putAudioMessage(id, "")
+	}
+	start = time.Now()
+	results, err = k.GenerateAudioBatch(context.Background(), "", []string{"r1", "r2", "r3"}, AudioBatchOptions{RateLimit: 5})
+	suite.Require().NoError(err)
+	suite.GreaterOrEqual(time.Since(start), 400*time.Millisecond)
+	for _, result := range results {
+		suite.NoError(result.Err)
+	}
+
+	// Messages which were not processed report the context error
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results, err = k.GenerateAudioBatch(ctx, testAIID, []string{"m1", "m2"}, AudioBatchOptions{})
+	suite.ErrorIs(err, context.Canceled)
+	suite.Require().Len(results, 2)
+	for _, result := range results {
+		suite.Error(result.Err)
+	}
+
+	_, err = NewKindroidAI("test_api_key", testAIID).GenerateAudioBatch(context.Background(), testAIID, []string{"m1"}, AudioBatchOptions{})
+	suite.ErrorIs(err, ErrJWTRequired)
+}
+
+func (suite *AudioTestSuite) TestInvalidOptions() {
+	for _, opts := range []AudioWaitOptions{
+		{Strategy: AudioWaitStrategy(42)},
+		{MaxWait: -time.Second},
+		{PollInterval: time.Second, MaxPollInterval: time.Millisecond},
+	} {
+		_, err := New(testutil.JWT(testUserID), WithAudioWait(opts))
+		suite.Error(err)
+	}
+}
+
+func TestAudioTestSuite(t *testing.T) {
+	suite.Run(t, new(AudioTestSuite))
+}
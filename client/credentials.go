@@ -0,0 +1,159 @@
+// Package client
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// UserIDEnv is the environment variable providing the UserID for static API keys.
+const UserIDEnv = "KINDROID_USER_ID"
+
+// ErrUserIDMismatch is returned if the UserID sources available to the client disagree.
+var ErrUserIDMismatch = errors.New("conflicting user IDs")
+
+// UserIDSource describes where the client's UserID was obtained from.
+type UserIDSource string
+
+const (
+	// UserIDSourceNone means no UserID has been resolved yet.
+	UserIDSourceNone UserIDSource = ""
+	// UserIDSourceOption means the UserID was configured explicitly using WithUserID.
+	UserIDSourceOption UserIDSource = "option"
+	// UserIDSourceJWT means the UserID was extracted from the user_id claim of the API key.
+	UserIDSourceJWT UserIDSource = "jwt"
+	// UserIDSourceEnv means the UserID was read from the KINDROID_USER_ID environment variable.
+	UserIDSourceEnv UserIDSource = "env"
+	// UserIDSourceSubscription means the UserID was looked up using the subscription endpoint.
+	UserIDSourceSubscription UserIDSource = "subscription"
+)
+
+// credentials is an immutable snapshot of a bearer token and the user derived from it.
+// Each request uses a single snapshot, so a rotating TokenSource cannot change its user midway.
+type credentials struct {
+	token   string
+	userID  string
+	source  UserIDSource
+	jwtAuth bool
+}
+
+// userIDCandidate is a UserID offered by a single source.
+type userIDCandidate struct {
+	source UserIDSource
+	userID string
+}
+
+// resolveUserID determines the UserID using the credential resolution chain:
+// explicit option, JWT claim, KINDROID_USER_ID environment variable and finally - only if
+// allowLookup is set - the subscription endpoint.
+// If more than one offline source provides a UserID, they must agree, otherwise ErrUserIDMismatch is returned.
+// The result is stored in the UserID, UserIDSource and JWTAuth fields.
+func (k *KindroidAI) resolveUserID(ctx context.Context, allowLookup bool) error {
+	var creds *credentials
+	var err error
+	switch {
+	case k.TokenSource != nil:
+		// The credentials derived from the current token are the ones used by requests
+		if creds, err = k.token(ctx); err != nil {
+			if errors.Is(err, ErrUserIDMismatch) {
+				k.JWTAuth = false
+			}
+			return fmt.Errorf("failed to setup user: %w", err)
+		}
+	case allowLookup && k.refresher != nil:
+		// Make sure the user ID and JWT authentication are derived from a current ID token
+		current, errToken := k.token(ctx)
+		if errToken != nil {
+			return fmt.Errorf("failed to setup user: %w", errToken)
+		}
+		creds, err = k.deriveCredentials(ctx, current.token)
+	default:
+		creds, err = k.deriveCredentials(ctx, k.currentToken())
+	}
+	if err != nil {
+		if errors.Is(err, ErrUserIDMismatch) {
+			k.JWTAuth = false
+		}
+		return err
+	}
+
+	k.JWTAuth = creds.jwtAuth
+	if creds.userID != "" {
+		k.UserID = creds.userID
+		k.UserIDSource = creds.source
+		return nil
+	}
+	if !allowLookup {
+		// Without lookup, the UserID stays unknown until SetupUserAndPermissions performs it.
+		return nil
+	}
+
+	// As a last resort, try to extract the UserID from subscription info
+	sub, errSub := k.CheckUserSubscriptionContext(ctx)
+	if errSub != nil {
+		_, _, errJWT := userIDFromJWT(creds.token)
+		return fmt.Errorf("failed to setup user. Failed to fetch subscription: %w Failed to parse API Key as JWT: %w ", errSub, errJWT)
+	}
+	if sub.UID == "" {
+		return fmt.Errorf("failed to setup user: subscription info does not contain a user ID")
+	}
+	k.UserID = sub.UID
+	k.UserIDSource = UserIDSourceSubscription
+	k.JWTAuth = false
+	return nil
+}
+
+// deriveCredentials runs the offline part of the credential resolution chain for the given bearer token.
+// The UserID of the result is empty if no offline source provides one. The client's fields are not changed.
+func (k *KindroidAI) deriveCredentials(ctx context.Context, token string) (*credentials, error) {
+	var candidates []userIDCandidate
+	if k.configuredUserID != "" {
+		candidates = append(candidates, userIDCandidate{UserIDSourceOption, k.configuredUserID})
+	}
+	jwtUserID, _, errJWT := userIDFromJWT(token)
+	if errJWT == nil && k.verifier != nil {
+		// A forged token must not determine the UserID, which is also the decryption key
+		if _, err := k.verifier.verify(ctx, k.Client, token); err != nil {
+			return nil, err
+		}
+	}
+	if errJWT == nil {
+		candidates = append(candidates, userIDCandidate{UserIDSourceJWT, jwtUserID})
+	}
+	if envUserID := os.Getenv(UserIDEnv); envUserID != "" {
+		candidates = append(candidates, userIDCandidate{UserIDSourceEnv, envUserID})
+	}
+	// Only if JWT Auth is given, Chat history and Audio Inference can be performed
+	creds := &credentials{token: token, jwtAuth: errJWT == nil}
+	if len(candidates) == 0 {
+		return creds, nil
+	}
+
+	chosen := candidates[0]
+	for _, candidate := range candidates[1:] {
+		if candidate.userID != chosen.userID {
+			return nil, fmt.Errorf("%w: %s provides %q, but %s provides %q",
+				ErrUserIDMismatch, chosen.source, chosen.userID, candidate.source, candidate.userID)
+		}
+	}
+	creds.userID = chosen.userID
+	creds.source = chosen.source
+	return creds, nil
+}
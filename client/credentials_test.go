@@ -0,0 +1,127 @@
+// Package client
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveUserID(t *testing.T) {
+	tests := []struct {
+		name      string
+		apiKey    string
+		option    string
+		env       string
+		userID    string
+		source    UserIDSource
+		jwtAuth   bool
+		mismatch  bool
+		unresolve bool
+	}{
+		{name: "option", apiKey: "static_key", option: "opt_user", userID: "opt_user", source: UserIDSourceOption},
+		{name: "jwt", apiKey: testutil.JWT("jwt_user"), userID: "jwt_user", source: UserIDSourceJWT, jwtAuth: true},
+		{name: "bearer prefixed jwt", apiKey: "Bearer " + testutil.JWT("jwt_user"), userID: "jwt_user", source: UserIDSourceJWT, jwtAuth: true},
+		{name: "env", apiKey: "static_key", env: "env_user", userID: "env_user", source: UserIDSourceEnv},
+		{name: "option and jwt agree", apiKey: testutil.JWT("same"), option: "same", userID: "same", source: UserIDSourceOption, jwtAuth: true},
+		{name: "jwt and env agree", apiKey: testutil.JWT("same"), env: "same", userID: "same", source: UserIDSourceJWT, jwtAuth: true},
+		{name: "option and jwt disagree", apiKey: testutil.JWT("jwt_user"), option: "opt_user", mismatch: true},
+		{name: "jwt and env disagree", apiKey: testutil.JWT("jwt_user"), env: "env_user", mismatch: true},
+		{name: "option and env disagree", apiKey: "static_key", option: "opt_user", env: "env_user", mismatch: true},
+		{name: "nothing offline", apiKey: "static_key", unresolve: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(UserIDEnv, tt.env)
+			var opts []Option
+			if tt.option != "" {
+				opts = append(opts, WithUserID(tt.option))
+			}
+
+			k, err := New(tt.apiKey, opts...)
+			if tt.mismatch {
+				assert.ErrorIs(t, err, ErrUserIDMismatch)
+				assert.Nil(t, k)
+
+				// The legacy constructor does not fail, but setup reports the conflict
+				legacy := NewKindroidAI(tt.apiKey, "test_ai_id")
+				legacy.configuredUserID = tt.option
+				assert.ErrorIs(t, legacy.SetupUserAndPermissions(), ErrUserIDMismatch)
+				return
+			}
+			require.NoError(t, err)
+
+			if tt.unresolve {
+				assert.Empty(t, k.UserID)
+				assert.Equal(t, UserIDSourceNone, k.UserIDSource)
+				return
+			}
+			assert.Equal(t, tt.userID, k.UserID)
+			assert.Equal(t, tt.source, k.UserIDSource)
+			assert.Equal(t, tt.jwtAuth, k.JWTAuth)
+		})
+	}
+}
+
+func TestResolveUserIDFromSubscription(t *testing.T) {
+	t.Setenv(UserIDEnv, "")
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		assert.Equal(t, "/check-user-subscription", r.URL.Path)
+		w.Write([]byte(`{"uid":"sub_user","status":"OK"}`))
+	}))
+	defer server.Close()
+
+	k, err := New("static_key", WithBaseURL(server.URL))
+	require.NoError(t, err)
+	assert.Zero(t, calls, "the subscription lookup must not happen during construction")
+
+	require.NoError(t, k.SetupUserAndPermissions())
+	assert.Equal(t, "sub_user", k.UserID)
+	assert.Equal(t, UserIDSourceSubscription, k.UserIDSource)
+	assert.False(t, k.JWTAuth)
+	assert.Equal(t, 1, calls)
+
+	// Offline sources take precedence over the lookup
+	t.Setenv(UserIDEnv, "env_user")
+	require.NoError(t, k.SetupUserAndPermissions())
+	assert.Equal(t, UserIDSourceEnv, k.UserIDSource)
+	assert.Equal(t, 1, calls)
+}
+
+func TestResolveUserIDFailure(t *testing.T) {
+	t.Setenv(UserIDEnv, "")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	k, err := New("static_key", WithBaseURL(server.URL))
+	require.NoError(t, err)
+
+	err = k.SetupUserAndPermissions()
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	assert.Empty(t, k.UserID)
+}
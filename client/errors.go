@@ -0,0 +1,102 @@
+// Package client
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Sentinel errors which can be checked using errors.Is.
+// API failures are reported as *APIError, which matches the sentinel corresponding to its status code.
+var (
+	ErrUnauthorized          = errors.New("unauthorized")
+	ErrRateLimited           = errors.New("rate limited")
+	ErrSubscriptionRequired  = errors.New("subscription required")
+	ErrJWTRequired           = errors.New("a JWT Bearer token must be provided as the API Key")
+	ErrDecryption            = errors.New("decryption failed")
+	ErrAudioNotReady         = errors.New("audio inference failed to produce an audio URL")
+	ErrUnexpectedContentType = errors.New("unexpected content type")
+)
+
+// maxErrorBodySize limits how much of an error response body is kept in an APIError.
+const maxErrorBodySize = 64 * 1024
+
+// requestIDHeaders lists response headers which may carry a request identifier, in order of preference.
+var requestIDHeaders = []string{"X-Request-Id", "X-Cloud-Trace-Context", "Function-Execution-Id"}
+
+// APIError is returned when the KindroidAI API responds with a non-successful HTTP status.
+type APIError struct {
+	StatusCode int
+	Status     string
+	Endpoint   string
+	Body       string
+	RequestID  string
+}
+
+// newAPIError builds an APIError from a failed response. The response body is consumed but not closed.
+func newAPIError(resp *http.Response, endpoint string) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Endpoint:   endpoint,
+	}
+	if bodyBytes, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize)); err == nil {
+		apiErr.Body = strings.TrimSpace(string(bodyBytes))
+	}
+	for _, header := range requestIDHeaders {
+		if requestID := resp.Header.Get(header); requestID != "" {
+			apiErr.RequestID = requestID
+			break
+		}
+	}
+	return apiErr
+}
+
+// Error implements the error interface.
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("HTTP error: %s (endpoint: %s", e.Status, e.Endpoint)
+	if e.RequestID != "" {
+		msg += ", request ID: " + e.RequestID
+	}
+	msg += ")"
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	return msg
+}
+
+// Is reports whether the error matches one of the package sentinels based on its status code.
+// A 403 response mentioning a subscription is treated as ErrSubscriptionRequired instead of ErrUnauthorized.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || (e.StatusCode == http.StatusForbidden && !e.mentionsSubscription())
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrSubscriptionRequired:
+		return e.StatusCode == http.StatusPaymentRequired || (e.StatusCode == http.StatusForbidden && e.mentionsSubscription())
+	}
+	return false
+}
+
+func (e *APIError) mentionsSubscription() bool {
+	return strings.Contains(strings.ToLower(e.Body), "subscri")
+}
@@ -0,0 +1,88 @@
+// Package client
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIErrorMapping(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		sentinel error
+	}{
+		{"unauthorized", http.StatusUnauthorized, `{"error":"invalid token"}`, ErrUnauthorized},
+		{"forbidden", http.StatusForbidden, `forbidden`, ErrUnauthorized},
+		{"rate limited", http.StatusTooManyRequests, `slow down`, ErrRateLimited},
+		{"payment required", http.StatusPaymentRequired, ``, ErrSubscriptionRequired},
+		{"forbidden subscription", http.StatusForbidden, `Subscription required for this feature`, ErrSubscriptionRequired},
+		{"server error", http.StatusInternalServerError, `boom`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Request-Id", "req-123")
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			k := NewKindroidAI("test_api_key", "test_ai_id")
+			k.BaseURL = server.URL
+
+			err := k.ChatBreak("Hello again")
+			require.Error(t, err)
+
+			var apiErr *APIError
+			require.True(t, errors.As(err, &apiErr), "error should be an *APIError")
+			assert.Equal(t, tt.status, apiErr.StatusCode)
+			assert.Equal(t, "chat-break", apiErr.Endpoint)
+			assert.Equal(t, tt.body, apiErr.Body)
+			assert.Equal(t, "req-123", apiErr.RequestID)
+
+			for _, sentinel := range []error{ErrUnauthorized, ErrRateLimited, ErrSubscriptionRequired} {
+				assert.Equal(t, sentinel == tt.sentinel, errors.Is(err, sentinel), "errors.Is(%v)", sentinel)
+			}
+		})
+	}
+}
+
+func TestJWTRequiredSentinel(t *testing.T) {
+	k := NewKindroidAI("test_api_key", "test_ai_id")
+
+	_, err := k.AudioInference("test_message_id")
+	assert.ErrorIs(t, err, ErrJWTRequired)
+
+	_, err = k.GetChatHistory(context.Background(), "test_ai_id", 10)
+	assert.ErrorIs(t, err, ErrJWTRequired)
+}
+
+func TestDecryptionSentinel(t *testing.T) {
+	_, err := decryptMessage("test_user", "!enc:not-valid-ciphertext")
+	assert.ErrorIs(t, err, ErrDecryption)
+}
@@ -0,0 +1,166 @@
+// Package client
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// firestoreEmulatorHostEnv is the environment variable used by Google's tooling to point clients at the Firestore emulator.
+const firestoreEmulatorHostEnv = "FIRESTORE_EMULATOR_HOST"
+
+// emulatorCredentials forwards the client's bearer token over the plaintext connection to a Firestore emulator,
+// so security rules based on request.auth are evaluated as they are in production.
+type emulatorCredentials struct {
+	k *KindroidAI
+}
+
+func (c emulatorCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	creds, err := c.k.token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"authorization": "Bearer " + creds.token}, nil
+}
+
+func (c emulatorCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// firestoreEmulatorHost returns the configured emulator host, falling back to the FIRESTORE_EMULATOR_HOST environment variable.
+func (k *KindroidAI) firestoreEmulatorHost() string {
+	if k.FirestoreEmulatorHost != "" {
+		return k.FirestoreEmulatorHost
+	}
+	return os.Getenv(firestoreEmulatorHostEnv)
+}
+
+// firestoreClientOptions returns the connection options for the Firestore client.
+func (k *KindroidAI) firestoreClientOptions() ([]option.ClientOption, error) {
+	if host := k.firestoreEmulatorHost(); host != "" {
+		conn, err := grpc.NewClient(host,
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithPerRPCCredentials(emulatorCredentials{k: k}),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to firestore emulator at %s: %w", host, err)
+		}
+		return []option.ClientOption{option.WithGRPCConn(conn)}, nil
+	}
+
+	// Use the client's current token as the bearer token for Firestore authentication.
+	return []option.ClientOption{option.WithTokenSource(clientTokenSource{k: k})}, nil
+}
+
+// sharedFirestore is a Firestore client shared by concurrent calls.
+// A retired client is closed once the last call using it has released it.
+type sharedFirestore struct {
+	client  *firestore.Client
+	token   string
+	refs    int
+	retired bool
+}
+
+// firestoreClient returns the shared Firestore client, creating it on first use.
+// The caller must call the returned release function once it no longer uses the client.
+// If the API key changed since the client was created, the client is rebuilt so the new token is used;
+// the outdated client stays open until all calls using it have released it.
+// Refreshed ID tokens are picked up by the client's token source without a rebuild.
+// It is safe for concurrent use.
+func (k *KindroidAI) firestoreClient(ctx context.Context) (*firestore.Client, func(), error) {
+	k.firestoreMu.Lock()
+	defer k.firestoreMu.Unlock()
+
+	if k.firestore != nil && k.firestore.token != k.APIKey {
+		k.retireFirestore()
+	}
+	if k.firestore == nil {
+		opts, err := k.firestoreClientOptions()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// Initialize the Firestore client.
+		// The client outlives the current call, so it must not be bound to the caller's cancellation.
+		client, err := firestore.NewClientWithDatabase(context.WithoutCancel(ctx), k.firestoreProject(), "(default)", opts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create firestore client: %w", err)
+		}
+		k.firestore = &sharedFirestore{client: client, token: k.APIKey}
+	}
+
+	shared := k.firestore
+	shared.refs++
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			k.firestoreMu.Lock()
+			defer k.firestoreMu.Unlock()
+			shared.refs--
+			if shared.retired && shared.refs == 0 {
+				k.closeFirestore(shared)
+			}
+		})
+	}
+	return shared.client, release, nil
+}
+
+// retireFirestore detaches the shared client, closing it right away if it is not in use. The caller must hold k.firestoreMu.
+func (k *KindroidAI) retireFirestore() error {
+	shared := k.firestore
+	k.firestore = nil
+	shared.retired = true
+	if shared.refs > 0 {
+		return nil
+	}
+	return k.closeFirestore(shared)
+}
+
+// closeFirestore closes a retired client. The caller must hold k.firestoreMu.
+func (k *KindroidAI) closeFirestore(shared *sharedFirestore) error {
+	err := shared.client.Close()
+	if err != nil {
+		k.logger().Warn("failed to close outdated firestore client", "error", err)
+	}
+	return err
+}
+
+// chatMessagesCollection returns a reference to the "ChatMessages" collection of the given user's AI.
+func chatMessagesCollection(client *firestore.Client, userID, aiID string) *firestore.CollectionRef {
+	return client.Collection(fmt.Sprintf("Users/%s/AIs/%s/ChatMessages", userID, aiID))
+}
+
+// Close releases the shared Firestore client, if one was created.
+// Calls still using the client, e.g. running watches, keep it open until they return.
+// The KindroidAI client remains usable; a new Firestore client is created on demand.
+func (k *KindroidAI) Close() error {
+	k.firestoreMu.Lock()
+	defer k.firestoreMu.Unlock()
+
+	if k.firestore == nil {
+		return nil
+	}
+	return k.retireFirestore()
+}
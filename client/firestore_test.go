@@ -0,0 +1,113 @@
+// Package client
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFirestoreClientLifecycle(t *testing.T) {
+	k := NewKindroidAI("test_api_key", "test_ai_id")
+	ctx := context.Background()
+
+	// Concurrent callers share a single lazily created client
+	clients := make([]*firestore.Client, 8)
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			client, release, err := k.firestoreClient(ctx)
+			assert.NoError(t, err)
+			release()
+			clients[i] = client
+		}(i)
+	}
+	wg.Wait()
+	for _, client := range clients {
+		assert.Same(t, clients[0], client)
+	}
+
+	// A changed token causes the client to be rebuilt
+	k.APIKey = "rotated_api_key"
+	rebuilt, release, err := k.firestoreClient(ctx)
+	require.NoError(t, err)
+	release()
+	assert.NotSame(t, clients[0], rebuilt)
+
+	// Close releases the client; a new one is created on demand
+	require.NoError(t, k.Close())
+	require.NoError(t, k.Close(), "closing twice must be safe")
+	recreated, release, err := k.firestoreClient(ctx)
+	require.NoError(t, err)
+	release()
+	assert.NotSame(t, rebuilt, recreated)
+	require.NoError(t, k.Close())
+}
+
+func TestFirestoreClientInUse(t *testing.T) {
+	fake := testutil.NewFirestore(t, DefaultFirestoreProject)
+	fake.PutMessage(t, testUserID, testAIID, testutil.Message{ID: "m1", Message: "Hello", Sender: SenderUser, Timestamp: 1000})
+	k, err := New(testutil.JWT(testUserID), WithKindroidID(testAIID), WithFirestoreEmulator(fake.Addr))
+	require.NoError(t, err)
+	ctx := context.Background()
+	get := func(client *firestore.Client) error {
+		_, err := chatMessagesCollection(client, testUserID, testAIID).Doc("m1").Get(ctx)
+		return err
+	}
+
+	// A client replaced after a token change stays usable until it is released
+	outdated, release, err := k.firestoreClient(ctx)
+	require.NoError(t, err)
+	k.APIKey = testutil.JWT(testUserID) + "rotated"
+	_, releaseRebuilt, err := k.firestoreClient(ctx)
+	require.NoError(t, err)
+	releaseRebuilt()
+	require.NoError(t, get(outdated))
+	release()
+	release()
+	assert.Error(t, get(outdated), "the outdated client must be closed after its last release")
+
+	// Close defers closing a client in use as well
+	inUse, release, err := k.firestoreClient(ctx)
+	require.NoError(t, err)
+	require.NoError(t, k.Close())
+	require.NoError(t, get(inUse))
+	release()
+	assert.Error(t, get(inUse))
+}
+
+func TestPageToken(t *testing.T) {
+	token := encodePageToken(&ChatMessage{ID: "msg_42", Timestamp: 1718000000123})
+	cursor, err := decodePageToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, "msg_42", cursor.ID)
+	assert.Equal(t, int64(1718000000123), cursor.Timestamp)
+
+	_, err = decodePageToken("not a token!")
+	assert.Error(t, err)
+	_, err = decodePageToken(encodePageToken(&ChatMessage{}))
+	assert.Error(t, err, "tokens without message ID must be rejected")
+}
@@ -0,0 +1,230 @@
+// Package client
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// DefaultPageSize is the number of messages returned per page if ListOptions.PageSize is not set.
+const DefaultPageSize = 50
+
+// ListOptions controls which chat messages are returned by ListChatMessages and AllChatMessages.
+// Messages are always returned newest first.
+type ListOptions struct {
+	// PageSize is the maximum number of messages per page. Defaults to DefaultPageSize.
+	PageSize int
+	// Before restricts the result to messages sent strictly before this time, if set.
+	Before time.Time
+	// After restricts the result to messages sent strictly after this time, if set.
+	After time.Time
+	// PageToken continues a previous listing. It must be used with the same Before/After filters.
+	PageToken string
+}
+
+// ChatMessagePage is a single page of chat messages.
+type ChatMessagePage struct {
+	Messages []*ChatMessage
+	// NextPageToken can be passed in ListOptions to fetch the next (older) page. It is empty on the last page.
+	NextPageToken string
+}
+
+// pageCursor is the decoded form of a page token, identifying the last message of a page.
+type pageCursor struct {
+	Timestamp int64  `json:"t"`
+	ID        string `json:"id"`
+}
+
+func encodePageToken(msg *ChatMessage) string {
+	data, _ := json.Marshal(pageCursor{Timestamp: msg.Timestamp, ID: msg.ID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodePageToken(token string) (*pageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid page token: %w", err)
+	}
+	var cursor pageCursor
+	if err = json.Unmarshal(data, &cursor); err != nil {
+		return nil, fmt.Errorf("invalid page token: %w", err)
+	}
+	if cursor.ID == "" {
+		return nil, errors.New("invalid page token: missing message ID")
+	}
+	return &cursor, nil
+}
+
+// cursorTimestamp returns the timestamp of a message document for a page cursor.
+// Firestore orders integers and doubles by their numeric value, so an integral double continues
+// the listing like the equal integer. Any other value would end the listing early, and is an error.
+func cursorTimestamp(doc *firestore.DocumentSnapshot) (int64, error) {
+	value, err := doc.DataAt("timestamp")
+	if err != nil {
+		return 0, fmt.Errorf("failed to build page token from message %s: %w", doc.Ref.ID, err)
+	}
+	switch timestamp := value.(type) {
+	case int64:
+		return timestamp, nil
+	case float64:
+		if float64(int64(timestamp)) == timestamp {
+			return int64(timestamp), nil
+		}
+	}
+	return 0, fmt.Errorf("failed to build page token from message %s: unsupported timestamp %v (%T)", doc.Ref.ID, value, value)
+}
+
+// checkFirestoreAccess verifies that the client is able to read from Firestore and returns the credentials to use.
+func (k *KindroidAI) checkFirestoreAccess(ctx context.Context, action string) (*credentials, error) {
+	creds, err := k.jwtCredentials(ctx, action)
+	if err != nil {
+		return nil, err
+	}
+	if creds.userID == "" {
+		return nil, fmt.Errorf("user ID not available; ensure APIKey is a valid JWT Bearer Token")
+	}
+	return creds, nil
+}
+
+// jwtCredentials returns the credentials for the next request, or an error wrapping ErrJWTRequired if they
+// do not provide JWT authentication. Without a TokenSource, no ID token is refreshed, since the UserID and
+// JWTAuth fields do not depend on it.
+func (k *KindroidAI) jwtCredentials(ctx context.Context, action string) (*credentials, error) {
+	creds := k.fieldCredentials("")
+	if k.TokenSource != nil {
+		// The source may have switched between a static API key and a JWT
+		var err error
+		if creds, err = k.token(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if !creds.jwtAuth {
+		return nil, fmt.Errorf("%s is currently unavailable: %w", action, ErrJWTRequired)
+	}
+	return creds, nil
+}
+
+// ListChatMessages returns a single page of chat messages for the given AI, newest first.
+// Use the returned NextPageToken to page further back in the history.
+func (k *KindroidAI) ListChatMessages(ctx context.Context, aiID string, opts ListOptions) (*ChatMessagePage, error) {
+	creds, err := k.checkFirestoreAccess(ctx, "fetching message history")
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize := opts.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+
+	client, release, err := k.firestoreClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Order by document ID as well, so messages sharing a timestamp have a stable order for the cursor.
+	query := chatMessagesCollection(client, creds.userID, aiID).
+		OrderBy("timestamp", firestore.Desc).
+		OrderBy(firestore.DocumentID, firestore.Desc)
+	if !opts.Before.IsZero() {
+		query = query.Where("timestamp", "<", opts.Before.UnixMilli())
+	}
+	if !opts.After.IsZero() {
+		query = query.Where("timestamp", ">", opts.After.UnixMilli())
+	}
+	if opts.PageToken != "" {
+		cursor, errToken := decodePageToken(opts.PageToken)
+		if errToken != nil {
+			return nil, errToken
+		}
+		query = query.StartAfter(cursor.Timestamp, cursor.ID)
+	}
+
+	// Fetch one additional document to find out whether another page exists.
+	docs, err := query.Limit(pageSize + 1).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve documents: %w", err)
+	}
+
+	page := &ChatMessagePage{}
+	hasMore := len(docs) > pageSize
+	if hasMore {
+		docs = docs[:pageSize]
+	}
+	page.Messages = k.messagesFromFirebaseDocuments(docs, creds.userID)
+	if hasMore {
+		// Build the cursor from the raw document, since parsing the last message might have failed.
+		last := docs[len(docs)-1]
+		timestamp, errTimestamp := cursorTimestamp(last)
+		if errTimestamp != nil {
+			return nil, errTimestamp
+		}
+		page.NextPageToken = encodePageToken(&ChatMessage{ID: last.Ref.ID, Timestamp: timestamp})
+	}
+	return page, nil
+}
+
+// ChatMessageIterator lazily walks through chat messages page by page.
+type ChatMessageIterator struct {
+	k        *KindroidAI
+	ctx      context.Context
+	aiID     string
+	opts     ListOptions
+	messages []*ChatMessage
+	done     bool
+	err      error
+}
+
+// AllChatMessages returns an iterator over the complete chat history of the given AI, newest first.
+// Pages are only fetched when needed. The filters of opts are applied; opts.PageSize controls the batch size.
+func (k *KindroidAI) AllChatMessages(ctx context.Context, aiID string, opts ListOptions) *ChatMessageIterator {
+	return &ChatMessageIterator{k: k, ctx: ctx, aiID: aiID, opts: opts}
+}
+
+// Next returns the next chat message. Once all messages were returned, it returns iterator.Done.
+func (it *ChatMessageIterator) Next() (*ChatMessage, error) {
+	for len(it.messages) == 0 {
+		if it.err != nil {
+			return nil, it.err
+		}
+		if it.done {
+			return nil, iterator.Done
+		}
+
+		page, err := it.k.ListChatMessages(it.ctx, it.aiID, it.opts)
+		if err != nil {
+			it.err = err
+			return nil, err
+		}
+		it.messages = page.Messages
+		it.opts.PageToken = page.NextPageToken
+		it.done = page.NextPageToken == ""
+	}
+
+	msg := it.messages[0]
+	it.messages = it.messages[1:]
+	return msg, nil
+}
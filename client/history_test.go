@@ -0,0 +1,280 @@
+// Package client
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+
+	pb "cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/internal/testutil"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	testUserID = "test_user"
+	testAIID   = "test_ai_id"
+)
+
+type HistoryTestSuite struct {
+	suite.Suite
+	Firestore *testutil.Firestore
+	Client    *KindroidAI
+	Token     string
+}
+
+func (suite *HistoryTestSuite) SetupTest() {
+	suite.Firestore = testutil.NewFirestore(suite.T(), DefaultFirestoreProject)
+	suite.Token = testutil.JWT(testUserID)
+
+	client, err := New(suite.Token, WithKindroidID(testAIID), WithFirestoreEmulator(suite.Firestore.Addr))
+	suite.Require().NoError(err)
+	suite.Require().NoError(client.SetupUserAndPermissions())
+	suite.Client = client
+
+	// Seed seven messages, two of them sharing a timestamp
+	timestamps := []int64{1000, 2000, 3000, 3000, 4000, 5000, 6000}
+	for i, timestamp := range timestamps {
+		sender := "user"
+		if i%2 == 1 {
+			sender = "ai"
+		}
+		suite.Firestore.PutMessage(suite.T(), testUserID, testAIID, testutil.Message{
+			ID:        fmt.Sprintf("msg_%d", i),
+			Message:   fmt.Sprintf("message %d", i),
+			Sender:    sender,
+			Timestamp: timestamp,
+		})
+	}
+}
+
+func (suite *HistoryTestSuite) TearDownTest() {
+	suite.Client.Close()
+}
+
+func (suite *HistoryTestSuite) ids(messages []*ChatMessage) []string {
+	var ids []string
+	for _, msg := range messages {
+		ids = append(ids, msg.ID)
+	}
+	return ids
+}
+
+func (suite *HistoryTestSuite) TestGetMessageByIdDecryption() {
+	suite.Firestore.PutMessage(suite.T(), testUserID, testAIID, testutil.Message{
+		ID: "with_audio", Message: "Hällo, wörld!", Sender: "ai", Timestamp: 7000, Audio: "https://example.com/audio.mp3",
+	})
+
+	msg, err := suite.Client.GetMessageById(context.Background(), testAIID, "with_audio")
+	suite.Require().NoError(err)
+	suite.Equal("with_audio", msg.ID)
+	suite.Equal("Hällo, wörld!", msg.Message)
+	suite.Equal("ai", msg.Sender)
+	suite.Equal(int64(7000), msg.Timestamp)
+	suite.Equal("https://example.com/audio.mp3", msg.Audio)
+
+	// The client's token is forwarded to Firestore
+	suite.Contains(suite.Firestore.Tokens(), suite.Token)
+
+	_, err = suite.Client.GetMessageById(context.Background(), testAIID, "missing")
+	suite.Error(err)
+}
+
+func (suite *HistoryTestSuite) TestDecryptionFailures() {
+	suite.Firestore.PutFields(fmt.Sprintf("Users/%s/AIs/%s/ChatMessages/plain", testUserID, testAIID), map[string]*pb.Value{
+		"message":   {ValueType: &pb.Value_StringValue{StringValue: "not encrypted"}},
+		"sender":    {ValueType: &pb.Value_StringValue{StringValue: "user"}},
+		"timestamp": {ValueType: &pb.Value_IntegerValue{IntegerValue: 8000}},
+	})
+	suite.Firestore.PutFields(fmt.Sprintf("Users/%s/AIs/%s/ChatMessages/corrupt", testUserID, testAIID), map[string]*pb.Value{
+		"message":   {ValueType: &pb.Value_StringValue{StringValue: "!enc:bm90IHZhbGlk"}},
+		"sender":    {ValueType: &pb.Value_StringValue{StringValue: "ai"}},
+		"timestamp": {ValueType: &pb.Value_IntegerValue{IntegerValue: 9000}},
+		// A salted payload whose ciphertext is not a whole number of AES blocks can never be decrypted,
+		// while a wrong key yields valid padding about once in 256 attempts
+		"audio": {ValueType: &pb.Value_StringValue{StringValue: "!enc:" + base64.StdEncoding.EncodeToString([]byte("Salted__saltsaltcut-short-data"))}},
+	})
+
+	msg, err := suite.Client.GetMessageById(context.Background(), testAIID, "plain")
+	suite.Require().NoError(err)
+	suite.Equal("not encrypted", msg.Message)
+
+	msg, err = suite.Client.GetMessageById(context.Background(), testAIID, "corrupt")
+	suite.Require().NoError(err)
+	suite.Equal("[DECRYPTION FAILED]", msg.Message)
+	suite.Equal("[DECRYPTION FAILED]", msg.Audio)
+}
+
+func (suite *HistoryTestSuite) TestGetChatHistory() {
+	messages, err := suite.Client.GetChatHistory(context.Background(), testAIID, 3)
+	suite.Require().NoError(err)
+	suite.Equal([]string{"msg_6", "msg_5", "msg_4"}, suite.ids(messages))
+	suite.Equal("message 6", messages[0].Message)
+}
+
+func (suite *HistoryTestSuite) TestEmulatorEnvironmentVariable() {
+	suite.T().Setenv(firestoreEmulatorHostEnv, suite.Firestore.Addr)
+	client := NewKindroidAI(suite.Token, testAIID)
+	suite.Require().NoError(client.SetupUserAndPermissions())
+	defer client.Close()
+
+	messages, err := client.GetChatHistory(context.Background(), testAIID, 1)
+	suite.Require().NoError(err)
+	suite.Equal([]string{"msg_6"}, suite.ids(messages))
+}
+
+func (suite *HistoryTestSuite) TestListChatMessagesPagination() {
+	ctx := context.Background()
+	var pages [][]string
+	opts := ListOptions{PageSize: 3}
+	for {
+		page, err := suite.Client.ListChatMessages(ctx, testAIID, opts)
+		suite.Require().NoError(err)
+		pages = append(pages, suite.ids(page.Messages))
+		if page.NextPageToken == "" {
+			break
+		}
+		opts.PageToken = page.NextPageToken
+	}
+	// Messages sharing a timestamp are ordered by ID and split across pages without loss
+	suite.Equal([][]string{{"msg_6", "msg_5", "msg_4"}, {"msg_3", "msg_2", "msg_1"}, {"msg_0"}}, pages)
+
+	_, err := suite.Client.ListChatMessages(ctx, testAIID, ListOptions{PageToken: "invalid!"})
+	suite.Error(err)
+}
+
+func (suite *HistoryTestSuite) TestListChatMessagesDoubleTimestamp() {
+	ctx := context.Background()
+	path := fmt.Sprintf("Users/%s/AIs/%s/ChatMessages/double", testUserID, testAIID)
+	putDouble := func(timestamp float64) {
+		suite.Firestore.PutFields(path, map[string]*pb.Value{
+			"message":   {ValueType: &pb.Value_StringValue{StringValue: testutil.Encrypt(suite.T(), testUserID, "double")}},
+			"sender":    {ValueType: &pb.Value_StringValue{StringValue: "ai"}},
+			"timestamp": {ValueType: &pb.Value_DoubleValue{DoubleValue: timestamp}},
+		})
+	}
+
+	// A page ending with an integral double timestamp continues at the right position
+	putDouble(2500)
+	var ids []string
+	opts := ListOptions{PageSize: 3}
+	for {
+		page, err := suite.Client.ListChatMessages(ctx, testAIID, opts)
+		suite.Require().NoError(err)
+		ids = append(ids, suite.ids(page.Messages)...)
+		if page.NextPageToken == "" {
+			break
+		}
+		opts.PageToken = page.NextPageToken
+	}
+	suite.Equal([]string{"msg_6", "msg_5", "msg_4", "msg_3", "msg_2", "double", "msg_1", "msg_0"}, ids)
+
+	// A timestamp which cannot be used as cursor fails instead of truncating the history
+	putDouble(2500.5)
+	page, err := suite.Client.ListChatMessages(ctx, testAIID, ListOptions{PageSize: 3})
+	suite.Require().NoError(err)
+	_, err = suite.Client.ListChatMessages(ctx, testAIID, ListOptions{PageSize: 3, PageToken: page.NextPageToken})
+	suite.ErrorContains(err, "unsupported timestamp")
+}
+
+func (suite *HistoryTestSuite) TestListChatMessagesFilters() {
+	page, err := suite.Client.ListChatMessages(context.Background(), testAIID, ListOptions{
+		After:  time.UnixMilli(1000),
+		Before: time.UnixMilli(5000),
+	})
+	suite.Require().NoError(err)
+	suite.Equal([]string{"msg_4", "msg_3", "msg_2", "msg_1"}, suite.ids(page.Messages))
+	suite.Empty(page.NextPageToken)
+}
+
+func (suite *HistoryTestSuite) TestAllChatMessages() {
+	it := suite.Client.AllChatMessages(context.Background(), testAIID, ListOptions{PageSize: 2})
+	var messages []*ChatMessage
+	for {
+		msg, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		suite.Require().NoError(err)
+		messages = append(messages, msg)
+	}
+	suite.Equal([]string{"msg_6", "msg_5", "msg_4", "msg_3", "msg_2", "msg_1", "msg_0"}, suite.ids(messages))
+
+	_, err := it.Next()
+	suite.Equal(iterator.Done, err, "the iterator stays exhausted")
+}
+
+func (suite *HistoryTestSuite) TestWatchChatMessages() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := suite.Client.WatchChatMessages(ctx, testAIID)
+	suite.Require().NoError(err)
+
+	next := func() ChatEvent {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(5 * time.Second):
+			suite.FailNow("timed out waiting for chat event")
+			return ChatEvent{}
+		}
+	}
+	now := time.Now().UnixMilli()
+
+	// Older messages are not replayed; new ones are reported
+	suite.Firestore.PutMessage(suite.T(), testUserID, testAIID, testutil.Message{ID: "new_1", Message: "hi there", Sender: "ai", Timestamp: now + 1})
+	event := next()
+	suite.Equal(ChatMessageAdded, event.Type)
+	suite.Equal("new_1", event.Message.ID)
+	suite.Equal("hi there", event.Message.Message)
+
+	suite.Firestore.PutMessage(suite.T(), testUserID, testAIID, testutil.Message{ID: "new_1", Message: "hi there", Sender: "ai", Timestamp: now + 1, Audio: "https://example.com/a.mp3"})
+	event = next()
+	suite.Equal(ChatMessageModified, event.Type)
+	suite.Equal("https://example.com/a.mp3", event.Message.Audio)
+
+	// The watch reconnects after a stream failure without replaying known messages
+	suite.Firestore.InterruptWatches()
+	event = next()
+	suite.Equal(ChatWatchError, event.Type)
+	suite.Error(event.Err)
+
+	suite.Firestore.PutMessage(suite.T(), testUserID, testAIID, testutil.Message{ID: "new_2", Message: "still here", Sender: "user", Timestamp: now + 2})
+	event = next()
+	suite.Equal(ChatMessageAdded, event.Type)
+	suite.Equal("new_2", event.Message.ID)
+
+	suite.Firestore.DeleteDoc(fmt.Sprintf("Users/%s/AIs/%s/ChatMessages/new_1", testUserID, testAIID))
+	event = next()
+	suite.Equal(ChatMessageRemoved, event.Type)
+	suite.Equal("new_1", event.Message.ID)
+
+	// Cancelling the context closes the channel
+	cancel()
+	for range events {
+	}
+}
+
+func TestHistoryTestSuite(t *testing.T) {
+	suite.Run(t, new(HistoryTestSuite))
+}
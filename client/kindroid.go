@@ -22,132 +22,278 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"cloud.google.com/go/firestore"
 	"github.com/Luzifer/go-openssl/v4"
-	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/oauth2"
-	"google.golang.org/api/option"
 )
 
 // KindroidAI stores session parameters for interacting with the KindroidAI API.
 type KindroidAI struct {
+	// APIKey is the API key or JWT sent with every request if no TokenSource is set.
+	// It is read without synchronization, so it must not be changed while requests are running.
 	APIKey     string
 	KindroidID string
 	BaseURL    string
 	Client     *http.Client
 	UserID     string
 	JWTAuth    bool
+	// UserIDSource reports where UserID was obtained from.
+	UserIDSource UserIDSource
+	// RetryPolicy configures retries for failed API requests. If nil, requests are not retried.
+	RetryPolicy *RetryPolicy
+	// UserAgent is sent with every REST request if not empty.
+	UserAgent string
+	// FirestoreProject is the Google Cloud project used for Firestore access.
+	FirestoreProject string
+	// FirestoreEmulatorHost connects Firestore access to an emulator (host:port) instead of Google's endpoint.
+	// If empty, the FIRESTORE_EMULATOR_HOST environment variable is honored.
+	FirestoreEmulatorHost string
+	// Logger receives warnings and diagnostic output. If nil, slog.Default() is used.
+	Logger *slog.Logger
+	// AudioWait configures how AudioInference waits for generated audio. If nil, DefaultAudioWaitOptions is used.
+	AudioWait *AudioWaitOptions
+	// AudioCache stores downloaded audio for replays. If nil, audio is downloaded every time.
+	AudioCache AudioCache
+	// TokenSource provides the bearer token for every request. If nil, APIKey is used.
+	// Each request derives JWT authentication and the UserID from the token it obtains, so a source switching
+	// between a static API key and a JWT enables or disables JWT-only features. The UserID, JWTAuth and
+	// UserIDSource fields only reflect the token at the time of New or SetupUserAndPermissions.
+	TokenSource oauth2.TokenSource
+	// TokenRefreshURL is the endpoint used to refresh ID tokens if a refresh token is configured.
+	// If empty, DefaultTokenRefreshURL is used.
+	TokenRefreshURL string
+
+	configuredUserID string
+	refresher        *tokenRefresher
+	verifier         *jwtVerifier
+
+	// derived holds the credentials derived from the last TokenSource token.
+	derived atomic.Pointer[credentials]
+
+	firestoreMu sync.Mutex
+	firestore   *sharedFirestore
 }
 
 // NewKindroidAI initializes a new KindroidAI client.
 // It will attempt to extract the UserID from the apiKey if it's a JWT.
 // If not, it will fall back to the KINDROID_USER_ID environment variable.
+//
+// Unlike New, conflicting UserIDs do not cause an error here; they are reported by SetupUserAndPermissions.
+// Use New for additional configuration options.
 func NewKindroidAI(apiKey, kindroidID string) *KindroidAI {
-	k := &KindroidAI{
-		APIKey:     apiKey,
-		KindroidID: kindroidID,
-		BaseURL:    "https://api.kindroid.ai/v1",
-		Client:     &http.Client{},
-	}
+	// WithKindroidID never fails, so newClient cannot return an error here.
+	k, _ := newClient(apiKey, WithKindroidID(kindroidID))
+	_ = k.resolveUserID(context.Background(), false)
 	return k
 }
 
-func (k *KindroidAI) SetupUserAndPermissions() error {
-	// Try to extract UserID from the APIKey (JWT)
-	userID, errJWT := k.extractUserIDFromJWT()
-	if errJWT != nil {
-		// if JWT extraction fails, try to extract from subscription info
-		sub, errSub := k.CheckUserSubscription()
-		if errSub != nil {
-			return fmt.Errorf("failed to setup user. Failed to fetch subscription: %w Failed to parse API Key as JWT: %w ", errSub, errJWT)
-		}
-		userID = sub.UID
-	} else {
-		// Only if JWT Auth is give, Chat history and Audio Inference can be performed
-		k.JWTAuth = true
+// logger returns the configured logger or the default logger.
+func (k *KindroidAI) logger() *slog.Logger {
+	if k.Logger != nil {
+		return k.Logger
 	}
-	k.UserID = userID
-	return nil
+	return slog.Default()
 }
 
-// extractUserIDFromJWT parses the JWT (APIKey) to get the user ID.
-func (k *KindroidAI) extractUserIDFromJWT() (string, error) {
-	tokenString := k.APIKey
-	if strings.HasPrefix(tokenString, "Bearer ") {
-		tokenString = strings.TrimPrefix(tokenString, "Bearer ")
+// firestoreProject returns the configured Firestore project or the default project.
+func (k *KindroidAI) firestoreProject() string {
+	if k.FirestoreProject != "" {
+		return k.FirestoreProject
 	}
+	return DefaultFirestoreProject
+}
+
+// SetupUserAndPermissions resolves the UserID and determines whether JWT-only features are available.
+// The UserID is taken from the first available source: WithUserID, the API key's JWT claim,
+// the KINDROID_USER_ID environment variable or the subscription endpoint.
+// UserIDSource reports which source was used.
+func (k *KindroidAI) SetupUserAndPermissions() error {
+	return k.SetupUserAndPermissionsContext(context.Background())
+}
+
+// SetupUserAndPermissionsContext is like SetupUserAndPermissions but uses the given context
+// for the subscription lookup.
+func (k *KindroidAI) SetupUserAndPermissionsContext(ctx context.Context) error {
+	return k.resolveUserID(ctx, true)
+}
+
+// extractUserIDFromJWT parses the JWT (APIKey or refreshed ID token) to get the user ID and its expiry.
+// The expiry is the zero time if the token does not contain an exp claim.
+func (k *KindroidAI) extractUserIDFromJWT() (string, time.Time, error) {
+	return userIDFromJWT(k.currentToken())
+}
 
-	token, _, err := jwt.NewParser().ParseUnverified(tokenString, jwt.MapClaims{})
+// userIDFromJWT returns the user ID and expiry of the given JWT.
+func userIDFromJWT(token string) (string, time.Time, error) {
+	claims, err := parseJWT(token)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse JWT: %w", err)
+		return "", time.Time{}, err
 	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
+	userID, ok := claims["user_id"].(string)
 	if !ok {
-		return "", fmt.Errorf("invalid JWT claims format")
+		return "", time.Time{}, fmt.Errorf("user_id not found or not a string in JWT claims")
 	}
 
-	userID, ok := claims["user_id"].(string)
-	if !ok {
-		return "", fmt.Errorf("user_id not found or not a string in JWT claims")
+	var expiresAt time.Time
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		expiresAt = exp.Time
 	}
+	return userID, expiresAt, nil
+}
 
-	return userID, nil
+// newRequest builds an authenticated JSON POST request for the given API endpoint.
+func (k *KindroidAI) newRequest(ctx context.Context, endpoint string, body []byte) (*http.Request, error) {
+	url := fmt.Sprintf("%s/%s", k.BaseURL, endpoint)
+	creds, err := k.token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+creds.token)
+	if k.UserAgent != "" {
+		req.Header.Set("User-Agent", k.UserAgent)
+	}
+	return req, nil
 }
 
 // SendMessage sends a message to the AI and returns the response.
 // This is the basic version for backwards compatibility.
 func (k *KindroidAI) SendMessage(message string) (string, error) {
+	return k.SendMessageContext(context.Background(), message)
+}
+
+// SendMessageContext is like SendMessage but uses the given context for the request.
+func (k *KindroidAI) SendMessageContext(ctx context.Context, message string) (string, error) {
 	options := SendMessageOptions{
 		AIID:    k.KindroidID,
 		Message: message,
 		Stream:  false, // Default to false for basic SendMessage
 	}
-	return k.SendMessageAdvanced(options)
+	return k.SendMessageAdvancedContext(ctx, options)
 }
 
 // SendMessageAdvanced sends a message to the AI with advanced options and returns the response.
 // This method supports multimedia, streaming, and other advanced features.
 func (k *KindroidAI) SendMessageAdvanced(options SendMessageOptions) (string, error) {
-	url := fmt.Sprintf("%s/send-message", k.BaseURL)
-	jsonData, err := json.Marshal(options)
+	return k.SendMessageAdvancedContext(context.Background(), options)
+}
+
+// SendMessageAdvancedContext is like SendMessageAdvanced but uses the given context for the request.
+// The response body is returned as is; use SendMessageWithResult to get the decoded reply.
+func (k *KindroidAI) SendMessageAdvancedContext(ctx context.Context, options SendMessageOptions) (string, error) {
+	bodyBytes, err := k.sendMessage(ctx, options)
 	if err != nil {
 		return "", err
 	}
+	return string(bodyBytes), nil
+}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+// SendMessageWithResult sends a message to the AI with advanced options and returns the decoded response.
+// The backend may respond with plain text, a JSON string or a JSON object; see SendMessageResult.
+func (k *KindroidAI) SendMessageWithResult(ctx context.Context, options SendMessageOptions) (*SendMessageResult, error) {
+	bodyBytes, err := k.sendMessage(ctx, options)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+k.APIKey)
+	return parseSendMessageResult(bodyBytes), nil
+}
 
-	resp, err := k.Client.Do(req)
+// sendMessage calls the send-message endpoint and returns the raw response body.
+func (k *KindroidAI) sendMessage(ctx context.Context, options SendMessageOptions) ([]byte, error) {
+	jsonData, err := json.Marshal(options)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("HTTP error: %s", resp.Status)
+	req, err := k.newRequest(ctx, "send-message", jsonData)
+	if err != nil {
+		return nil, err
 	}
 
-	bodyBytes, err := io.ReadAll(resp.Body)
+	resp, err := k.do(req, "send-message", false)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	defer resp.Body.Close()
 
-	return string(bodyBytes), nil
+	return io.ReadAll(resp.Body)
+}
+
+// Fields of a JSON object response which may contain the reply text or the message ID, in order of preference.
+var (
+	replyTextFields = []string{"reply", "response", "message", "text", "content"}
+	messageIDFields = []string{"message_id", "messageID", "messageId", "id"}
+)
+
+// parseSendMessageResult decodes a send-message response body.
+// Bodies which are neither a JSON string nor a JSON object are treated as plain text.
+func parseSendMessageResult(body []byte) *SendMessageResult {
+	result := &SendMessageResult{Text: string(body), Raw: body}
+	trimmed := bytes.TrimSpace(body)
+
+	var text string
+	if err := json.Unmarshal(trimmed, &text); err == nil {
+		result.Text = text
+		return result
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(trimmed, &fields); err != nil || fields == nil {
+		return result
+	}
+	result.Text = ""
+	for _, name := range replyTextFields {
+		if value, ok := fields[name].(string); ok {
+			result.Text = value
+			delete(fields, name)
+			break
+		}
+	}
+	for _, name := range messageIDFields {
+		switch value := fields[name].(type) {
+		case string:
+			result.MessageID = value
+		case float64:
+			result.MessageID = strconv.FormatFloat(value, 'f', -1, 64)
+		default:
+			continue
+		}
+		delete(fields, name)
+		break
+	}
+	if len(fields) > 0 {
+		result.Metadata = fields
+	}
+	return result
 }
 
 // ChatBreak ends the current chat session and starts a new one with a customizable greeting sent by the AI.
 func (k *KindroidAI) ChatBreak(greeting string) error {
-	url := fmt.Sprintf("%s/chat-break", k.BaseURL)
+	return k.ChatBreakContext(context.Background(), greeting)
+}
+
+// ChatBreakContext is like ChatBreak but uses the given context for the request.
+func (k *KindroidAI) ChatBreakContext(ctx context.Context, greeting string) error {
+	return k.ChatBreakAI(ctx, k.KindroidID, greeting)
+}
+
+// ChatBreakAI is like ChatBreakContext but ends the chat with the given AI instead of the client's default AI.
+func (k *KindroidAI) ChatBreakAI(ctx context.Context, aiID, greeting string) error {
 	requestBody := map[string]string{
-		"ai_id":    k.KindroidID,
+		"ai_id":    aiID,
 		"greeting": greeting,
 	}
 	jsonData, err := json.Marshal(requestBody)
@@ -155,23 +301,17 @@ func (k *KindroidAI) ChatBreak(greeting string) error {
 		return err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, err := k.newRequest(ctx, "chat-break", jsonData)
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+k.APIKey)
 
-	resp, err := k.Client.Do(req)
+	resp, err := k.do(req, "chat-break", false)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("HTTP error: %s", resp.Status)
-	}
-
 	return nil
 }
 
@@ -181,27 +321,25 @@ func (k *KindroidAI) ChatBreak(greeting string) error {
 // network analysis. It may change or be removed without notice.
 // Use at your own risk in production environments.
 func (k *KindroidAI) CheckUserSubscription() (*SubscriptionInfo, error) {
-	url := fmt.Sprintf("%s/check-user-subscription", k.BaseURL)
+	return k.CheckUserSubscriptionContext(context.Background())
+}
+
+// CheckUserSubscriptionContext is like CheckUserSubscription but uses the given context for the request.
+func (k *KindroidAI) CheckUserSubscriptionContext(ctx context.Context) (*SubscriptionInfo, error) {
 	// The HAR file shows an empty JSON object as the request body.
 	jsonData := []byte("{}")
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, err := k.newRequest(ctx, "check-user-subscription", jsonData)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+k.APIKey)
 
-	resp, err := k.Client.Do(req)
+	resp, err := k.do(req, "check-user-subscription", true)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP error: %s", resp.Status)
-	}
-
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
@@ -222,55 +360,29 @@ func (k *KindroidAI) CheckUserSubscription() (*SubscriptionInfo, error) {
 // network analysis. It may change or be removed without notice.
 // Use at your own risk in production environments.
 func (k *KindroidAI) AudioInference(messageID string) ([]byte, error) {
-	if !k.JWTAuth {
-		return nil, fmt.Errorf("audio inference is currently only available if a JWT Bearer token is provided as the API Key")
-	}
-
-	// Fetch the message for given ID and check for Audio URL
-	message, errMessage := k.GetMessageById(context.Background(), k.KindroidID, messageID)
-	if errMessage != nil {
-		return nil, fmt.Errorf("failed to fetch message for ID %s: %w", messageID, errMessage)
-	}
-
-	// If no Audio URL is present, invoke inference endpoint and fetch the message a second time
-	if message.Audio == "" {
-		if errInference := k.invokeBackendAudioInference(messageID); errInference != nil {
-			return nil, fmt.Errorf("failed to invoke backend audio inference API: %w", errInference)
-		}
-		// Fetch the message a second time
-		message, errMessage = k.GetMessageById(context.Background(), k.KindroidID, messageID)
-		if errMessage != nil {
-			return nil, fmt.Errorf("failed to fetch message for ID %s after invoking audio inference: %w", messageID, errMessage)
-		}
-		if message.Audio == "" {
-			return nil, fmt.Errorf("audio inference failed to produce an audio URL for message ID %s", messageID)
-		}
-	}
+	return k.AudioInferenceContext(context.Background(), messageID)
+}
 
-	// Fetch the audio
-	resp, err := http.Get(message.Audio)
+// AudioInferenceContext is like AudioInference but uses the given context for the Firestore lookups,
+// the inference request and the audio download.
+func (k *KindroidAI) AudioInferenceContext(ctx context.Context, messageID string) ([]byte, error) {
+	stream, _, err := k.AudioStream(ctx, messageID)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	defer stream.Close()
 
-	// Decode the audio
-	audio, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
 	// Returned audio is audio/mpeg
-	return audio, nil
+	return io.ReadAll(stream)
 }
 
-func (k *KindroidAI) invokeBackendAudioInference(messageID string) error {
-	if !k.JWTAuth {
-		return fmt.Errorf("audio inference is currently only available if a JWT Bearer token is provided as the API Key")
+func (k *KindroidAI) invokeBackendAudioInference(ctx context.Context, aiID, messageID string) error {
+	if _, err := k.jwtCredentials(ctx, "audio inference"); err != nil {
+		return err
 	}
 
-	url := fmt.Sprintf("%s/audio-inference", k.BaseURL)
 	requestBody := AudioInferenceRequest{
-		AIID:      k.KindroidID,
+		AIID:      aiID,
 		MessageID: messageID,
 	}
 	jsonData, err := json.Marshal(requestBody)
@@ -278,27 +390,21 @@ func (k *KindroidAI) invokeBackendAudioInference(messageID string) error {
 		return err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, err := k.newRequest(ctx, "audio-inference", jsonData)
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+k.APIKey)
 
-	resp, err := k.Client.Do(req)
+	resp, err := k.do(req, "audio-inference", true)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("HTTP error: %s", resp.Status)
-	}
 	return nil
 }
 
-// decryptMessage decrypts a message string that is prefixed with "!enc:".
-func (k *KindroidAI) decryptMessage(encryptedMsg string) (string, error) {
+// decryptMessage decrypts a message string that is prefixed with "!enc:" using the user's ID as key.
+func decryptMessage(userID, encryptedMsg string) (string, error) {
 	if !strings.HasPrefix(encryptedMsg, "!enc:") {
 		// Not encrypted, return as is
 		return encryptedMsg, nil
@@ -307,39 +413,28 @@ func (k *KindroidAI) decryptMessage(encryptedMsg string) (string, error) {
 	trimmedMsg := strings.TrimPrefix(encryptedMsg, "!enc:")
 
 	o := openssl.New()
-	decrypted, err := o.DecryptBytes(k.UserID, []byte(trimmedMsg), openssl.BytesToKeyMD5)
+	decrypted, err := o.DecryptBytes(userID, []byte(trimmedMsg), openssl.BytesToKeyMD5)
 	if err != nil {
-		return "", fmt.Errorf("failed to decrypt message: %w", err)
+		return "", fmt.Errorf("failed to decrypt message: %w: %w", ErrDecryption, err)
 	}
 
 	return string(decrypted), nil
 }
 
 func (k *KindroidAI) GetMessageById(ctx context.Context, aiID string, messageID string) (*ChatMessage, error) {
-	if !k.JWTAuth {
-		return nil, fmt.Errorf("fetching messages is currently only available if a JWT Bearer Token is provided as the API Key")
-	}
-	if k.UserID == "" {
-		return nil, fmt.Errorf("user ID not available; ensure APIKey is a valid JWT Bearer Token")
+	creds, err := k.checkFirestoreAccess(ctx, "fetching messages")
+	if err != nil {
+		return nil, err
 	}
 
-	// Use the APIKey as the bearer token for Firestore authentication.
-	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: k.APIKey})
-	opts := []option.ClientOption{option.WithTokenSource(ts)}
-
-	// Initialize the Firestore client.
-	// Note: The project ID is hardcoded based on HAR file analysis.
-	client, err := firestore.NewClientWithDatabase(ctx, "kindroid-ai", "(default)", opts...)
+	client, release, err := k.firestoreClient(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create firestore client: %w", err)
+		return nil, err
 	}
-	defer client.Close()
-
-	// Construct the path to the "ChatMessages" collection.
-	parentPath := fmt.Sprintf("Users/%s/AIs/%s", k.UserID, aiID)
+	defer release()
 
 	// Build the query.
-	query := client.Collection(parentPath + "/ChatMessages").Doc(messageID)
+	query := chatMessagesCollection(client, creds.userID, aiID).Doc(messageID)
 
 	// Execute the query.
 	doc, err := query.Get(ctx)
@@ -348,10 +443,10 @@ func (k *KindroidAI) GetMessageById(ctx context.Context, aiID string, messageID
 	}
 
 	// Parse and decrypt the message
-	return k.messageFromFirebaseDocument(doc)
+	return k.messageFromFirebaseDocument(doc, creds.userID)
 }
 
-func (k *KindroidAI) messageFromFirebaseDocument(doc *firestore.DocumentSnapshot) (*ChatMessage, error) {
+func (k *KindroidAI) messageFromFirebaseDocument(doc *firestore.DocumentSnapshot, userID string) (*ChatMessage, error) {
 	msg := &ChatMessage{}
 	if errDecode := doc.DataTo(&msg); errDecode != nil {
 		return nil, errDecode
@@ -359,20 +454,20 @@ func (k *KindroidAI) messageFromFirebaseDocument(doc *firestore.DocumentSnapshot
 	msg.ID = doc.Ref.ID
 
 	// Decrypt the message content if it's encrypted.
-	decryptedText, errMessage := k.decryptMessage(msg.Message)
+	decryptedText, errMessage := decryptMessage(userID, msg.Message)
 	if errMessage != nil {
-		fmt.Printf("Warning: Failed to decrypt message for doc %s: %v\n", doc.Ref.ID, errMessage)
-		msg.Message = "[DECRYPTION FAILED]"
+		k.logger().Warn("failed to decrypt message", "doc", doc.Ref.ID, "error", errMessage)
+		msg.Message = DecryptionFailed
 	} else {
 		msg.Message = decryptedText
 	}
 
 	// Decrypt the audio content if it's present and encrypted.
 	if msg.Audio != "" {
-		decryptedAudioInfo, errAudio := k.decryptMessage(msg.Audio)
+		decryptedAudioInfo, errAudio := decryptMessage(userID, msg.Audio)
 		if errAudio != nil {
-			fmt.Printf("Warning: Failed to decrypt message for doc %s: %v\n", doc.Ref.ID, errAudio)
-			msg.Audio = "[DECRYPTION FAILED]"
+			k.logger().Warn("failed to decrypt audio info", "doc", doc.Ref.ID, "error", errAudio)
+			msg.Audio = DecryptionFailed
 		} else {
 			msg.Audio = decryptedAudioInfo
 		}
@@ -385,30 +480,19 @@ func (k *KindroidAI) messageFromFirebaseDocument(doc *firestore.DocumentSnapshot
 
 // GetChatHistory retrieves the most recent chat messages for a given AI from Firestore.
 func (k *KindroidAI) GetChatHistory(ctx context.Context, aiID string, limit int) ([]*ChatMessage, error) {
-	if !k.JWTAuth {
-		return nil, fmt.Errorf("fetching message history is currently only available if a JWT Bearer Token is provided as the API Key")
-	}
-	if k.UserID == "" {
-		return nil, fmt.Errorf("user ID not available; ensure APIKey is a valid JWT Bearer Token")
+	creds, err := k.checkFirestoreAccess(ctx, "fetching message history")
+	if err != nil {
+		return nil, err
 	}
 
-	// Use the APIKey as the bearer token for Firestore authentication.
-	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: k.APIKey})
-	opts := []option.ClientOption{option.WithTokenSource(ts)}
-
-	// Initialize the Firestore client.
-	// Note: The project ID is hardcoded based on HAR file analysis.
-	client, err := firestore.NewClientWithDatabase(ctx, "kindroid-ai", "(default)", opts...)
+	client, release, err := k.firestoreClient(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create firestore client: %w", err)
+		return nil, err
 	}
-	defer client.Close()
-
-	// Construct the path to the "ChatMessages" collection.
-	parentPath := fmt.Sprintf("Users/%s/AIs/%s", k.UserID, aiID)
+	defer release()
 
 	// Build the query.
-	query := client.Collection(parentPath+"/ChatMessages").
+	query := chatMessagesCollection(client, creds.userID, aiID).
 		OrderBy("timestamp", firestore.Desc).
 		Limit(limit)
 
@@ -419,15 +503,19 @@ func (k *KindroidAI) GetChatHistory(ctx context.Context, aiID string, limit int)
 	}
 
 	// Parse and decrypt the documents.
+	return k.messagesFromFirebaseDocuments(docs, creds.userID), nil
+}
+
+// messagesFromFirebaseDocuments parses and decrypts a list of documents, skipping documents which cannot be parsed.
+func (k *KindroidAI) messagesFromFirebaseDocuments(docs []*firestore.DocumentSnapshot, userID string) []*ChatMessage {
 	var messages []*ChatMessage
 	for _, doc := range docs {
-		msg, errDecode := k.messageFromFirebaseDocument(doc)
+		msg, errDecode := k.messageFromFirebaseDocument(doc, userID)
 		if errDecode != nil {
-			fmt.Printf("Warning: Failed to parse chat message document %s: %v\n", doc.Ref.ID, errDecode)
+			k.logger().Warn("failed to parse chat message document", "doc", doc.Ref.ID, "error", errDecode)
 			continue
 		}
 		messages = append(messages, msg)
 	}
-
-	return messages, nil
+	return messages
 }
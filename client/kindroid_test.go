@@ -18,21 +18,26 @@ limitations under the License.
 package client
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/internal/testutil"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
 
 type KindroidAITestSuite struct {
 	suite.Suite
-	Server *httptest.Server
-	Client *KindroidAI
+	Server    *httptest.Server
+	Firestore *testutil.Firestore
+	Client    *KindroidAI
 }
 
 func (suite *KindroidAITestSuite) SetupTest() {
+	suite.Firestore = testutil.NewFirestore(suite.T(), DefaultFirestoreProject)
 
 	suite.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
@@ -98,9 +103,19 @@ func (suite *KindroidAITestSuite) SetupTest() {
 			expectedBody := `{"ai_id":"test_ai_id","messageID":"test_message_id"}`
 			suite.JSONEq(expectedBody, string(bodyBytes), "Invalid request body")
 
+			// Simulate the backend generating the audio and storing its URL with the message
+			suite.Firestore.PutMessage(suite.T(), testUserID, "test_ai_id", testutil.Message{
+				ID: "test_message_id", Message: "Hello, user!", Sender: "ai", Timestamp: 1000, Audio: suite.Server.URL + "/audio.mp3",
+			})
+
 			w.WriteHeader(http.StatusOK)
 			w.Write([]byte(`"OK"`)) // As per HAR, simple "OK" response
 
+		case "/audio.mp3":
+			suite.Equal(http.MethodGet, r.Method, "Expected method GET")
+			w.Header().Set("Content-Type", "audio/mpeg")
+			w.Write([]byte("ID3-fake-mpeg-data"))
+
 		default:
 			w.WriteHeader(http.StatusNotFound)
 		}
@@ -133,11 +148,43 @@ func (suite *KindroidAITestSuite) TestSendMessageAdvanced() {
 	suite.Equal(`"Hello, advanced user!"`, response, "Unexpected advanced response")
 }
 
+func (suite *KindroidAITestSuite) TestSendMessageWithResult() {
+	result, err := suite.Client.SendMessageWithResult(context.Background(), SendMessageOptions{
+		AIID:    suite.Client.KindroidID,
+		Message: "Hello",
+	})
+	suite.NoError(err, "SendMessageWithResult returned an error")
+	suite.Equal("Hello, user!", result.Text, "The JSON quoted reply should be decoded")
+	suite.Equal(`"Hello, user!"`, string(result.Raw))
+	suite.Empty(result.MessageID)
+}
+
+func (suite *KindroidAITestSuite) TestContextCancellation() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := suite.Client.SendMessageContext(ctx, "Hello")
+	suite.ErrorIs(err, context.Canceled, "SendMessageContext should honor a cancelled context")
+
+	err = suite.Client.ChatBreakContext(ctx, "Hello again")
+	suite.ErrorIs(err, context.Canceled, "ChatBreakContext should honor a cancelled context")
+
+	_, err = suite.Client.CheckUserSubscriptionContext(ctx)
+	suite.ErrorIs(err, context.Canceled, "CheckUserSubscriptionContext should honor a cancelled context")
+}
+
 func (suite *KindroidAITestSuite) TestChatBreak() {
 	err := suite.Client.ChatBreak("Hello again")
 	suite.NoError(err, "ChatBreak returned an error")
 }
 
+func (suite *KindroidAITestSuite) TestChatBreakAI() {
+	k := NewKindroidAI("test_api_key", "other_ai_id")
+	k.BaseURL = suite.Server.URL
+	err := k.ChatBreakAI(context.Background(), "test_ai_id", "Hello again")
+	suite.NoError(err, "ChatBreakAI returned an error")
+}
+
 func (suite *KindroidAITestSuite) TestCheckUserSubscription() {
 	subInfo, err := suite.Client.CheckUserSubscription()
 	suite.NoError(err, "CheckUserSubscription returned an error")
@@ -149,8 +196,20 @@ func (suite *KindroidAITestSuite) TestCheckUserSubscription() {
 }
 
 func (suite *KindroidAITestSuite) TestAudioInference() {
-	err := suite.Client.AudioInference("test_message_id")
+	// Audio inference requires Firestore access, which is served by the in-process fake
+	suite.Client.JWTAuth = true
+	suite.Client.UserID = testUserID
+	suite.Client.FirestoreEmulatorHost = suite.Firestore.Addr
+	defer suite.Client.Close()
+
+	// The message has no audio yet, so the backend inference is invoked and the message is fetched again
+	suite.Firestore.PutMessage(suite.T(), testUserID, "test_ai_id", testutil.Message{
+		ID: "test_message_id", Message: "Hello, user!", Sender: "ai", Timestamp: 1000,
+	})
+
+	audio, err := suite.Client.AudioInference("test_message_id")
 	suite.NoError(err, "AudioInference returned an error")
+	suite.Equal("ID3-fake-mpeg-data", string(audio))
 }
 
 func (suite *KindroidAITestSuite) TestExtractUserIDFromJWT() {
@@ -176,6 +235,33 @@ func (suite *KindroidAITestSuite) TestExtractUserIDFromJWT() {
 	suite.Empty(tempClientMissingClaim.UserID, "UserID should be empty for JWT missing user_id claim")
 }
 
+func TestParseSendMessageResult(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		text      string
+		messageID string
+		metadata  map[string]any
+	}{
+		{"plain text", "Hello, user!", "Hello, user!", "", nil},
+		{"JSON string", `"Hello,\n\"user\" \u00e4"`, "Hello,\n\"user\" ä", "", nil},
+		{"JSON object", `{"reply":"Hi","message_id":"msg_1","tokens":12}`, "Hi", "msg_1", map[string]any{"tokens": float64(12)}},
+		{"alternative field names", `{"response":"Hi","messageId":42}`, "Hi", "42", nil},
+		{"object without text", `{"status":"queued"}`, "", "", map[string]any{"status": "queued"}},
+		{"malformed JSON", `{"reply": "Hi"`, `{"reply": "Hi"`, "", nil},
+		{"JSON array", `["Hi"]`, `["Hi"]`, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := parseSendMessageResult([]byte(tt.body))
+			assert.Equal(t, tt.text, result.Text)
+			assert.Equal(t, tt.messageID, result.MessageID)
+			assert.Equal(t, tt.metadata, result.Metadata)
+			assert.Equal(t, tt.body, string(result.Raw))
+		})
+	}
+}
+
 func TestKindroidAITestSuite(t *testing.T) {
 	suite.Run(t, new(KindroidAITestSuite))
 }
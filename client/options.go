@@ -0,0 +1,322 @@
+// Package client
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	// DefaultBaseURL is the base URL of the public KindroidAI API.
+	DefaultBaseURL = "https://api.kindroid.ai/v1"
+	// DefaultFirestoreProject is the Google Cloud project hosting Kindroid's Firestore database.
+	DefaultFirestoreProject = "kindroid-ai"
+)
+
+// Option configures a KindroidAI client created with New.
+type Option func(k *KindroidAI) error
+
+// New creates a KindroidAI client for the given API key and applies the given options.
+// The UserID is resolved from the offline sources (WithUserID, JWT claim, KINDROID_USER_ID);
+// call SetupUserAndPermissions to fall back to the subscription lookup.
+// An error is returned if any of the options is invalid or the UserID sources disagree.
+func New(apiKey string, opts ...Option) (*KindroidAI, error) {
+	k, err := newClient(apiKey, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err = k.resolveUserID(context.Background(), false); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// newClient creates a client with default settings and applies the given options.
+func newClient(apiKey string, opts ...Option) (*KindroidAI, error) {
+	k := &KindroidAI{
+		APIKey:           apiKey,
+		BaseURL:          DefaultBaseURL,
+		Client:           &http.Client{},
+		FirestoreProject: DefaultFirestoreProject,
+	}
+	for _, opt := range opts {
+		if err := opt(k); err != nil {
+			return nil, fmt.Errorf("invalid client option: %w", err)
+		}
+	}
+	return k, nil
+}
+
+// WithKindroidID sets the default AI ID used by SendMessage, ChatBreak and AudioInference.
+func WithKindroidID(kindroidID string) Option {
+	return func(k *KindroidAI) error {
+		k.KindroidID = kindroidID
+		return nil
+	}
+}
+
+// WithUserID explicitly sets the UserID, which is required for chat history access with static API keys.
+// It takes precedence over all other sources, but must match the user_id claim if the API key is a JWT.
+func WithUserID(userID string) Option {
+	return func(k *KindroidAI) error {
+		if strings.TrimSpace(userID) == "" {
+			return errors.New("user ID must not be empty")
+		}
+		k.configuredUserID = userID
+		return nil
+	}
+}
+
+// WithBaseURL overrides the API base URL, e.g. for proxies or test servers.
+func WithBaseURL(baseURL string) Option {
+	return func(k *KindroidAI) error {
+		parsed, err := url.Parse(baseURL)
+		if err != nil {
+			return fmt.Errorf("failed to parse base URL: %w", err)
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return fmt.Errorf("base URL must use http or https, got %q", baseURL)
+		}
+		if parsed.Host == "" {
+			return fmt.Errorf("base URL must contain a host, got %q", baseURL)
+		}
+		k.BaseURL = strings.TrimSuffix(baseURL, "/")
+		return nil
+	}
+}
+
+// WithHTTPClient sets the HTTP client used for all REST requests.
+// It can be used to plug in custom transports, proxies or instrumentation.
+func WithHTTPClient(client *http.Client) Option {
+	return func(k *KindroidAI) error {
+		if client == nil {
+			return errors.New("HTTP client must not be nil")
+		}
+		// Keep a timeout configured by an earlier WithTimeout option
+		if k.Client != nil && k.Client.Timeout > 0 && client.Timeout == 0 {
+			c := *client
+			c.Timeout = k.Client.Timeout
+			client = &c
+		}
+		k.Client = client
+		return nil
+	}
+}
+
+// WithTimeout sets the overall timeout for a single HTTP request.
+// The configured HTTP client is copied, so a client passed to WithHTTPClient is not modified.
+func WithTimeout(timeout time.Duration) Option {
+	return func(k *KindroidAI) error {
+		if timeout <= 0 {
+			return fmt.Errorf("timeout must be positive, got %s", timeout)
+		}
+		c := *k.Client
+		c.Timeout = timeout
+		k.Client = &c
+		return nil
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with every REST request.
+func WithUserAgent(userAgent string) Option {
+	return func(k *KindroidAI) error {
+		if strings.TrimSpace(userAgent) == "" {
+			return errors.New("user agent must not be empty")
+		}
+		k.UserAgent = userAgent
+		return nil
+	}
+}
+
+// WithFirestoreProject overrides the Google Cloud project used for Firestore access.
+func WithFirestoreProject(projectID string) Option {
+	return func(k *KindroidAI) error {
+		if strings.TrimSpace(projectID) == "" {
+			return errors.New("firestore project ID must not be empty")
+		}
+		k.FirestoreProject = projectID
+		return nil
+	}
+}
+
+// WithFirestoreEmulator connects Firestore access to an emulator or local stand-in at the given host:port.
+// The connection is not encrypted. The FIRESTORE_EMULATOR_HOST environment variable is honored without this option.
+func WithFirestoreEmulator(host string) Option {
+	return func(k *KindroidAI) error {
+		if strings.TrimSpace(host) == "" {
+			return errors.New("firestore emulator host must not be empty")
+		}
+		k.FirestoreEmulatorHost = host
+		return nil
+	}
+}
+
+// WithLogger sets the logger used for warnings and diagnostic output.
+func WithLogger(logger *slog.Logger) Option {
+	return func(k *KindroidAI) error {
+		if logger == nil {
+			return errors.New("logger must not be nil")
+		}
+		k.Logger = logger
+		return nil
+	}
+}
+
+// WithRetryPolicy enables retries for failed requests according to the given policy.
+func WithRetryPolicy(policy *RetryPolicy) Option {
+	return func(k *KindroidAI) error {
+		if policy == nil {
+			return errors.New("retry policy must not be nil")
+		}
+		if policy.MaxAttempts < 1 {
+			return fmt.Errorf("retry policy must allow at least one attempt, got %d", policy.MaxAttempts)
+		}
+		if policy.InitialBackoff < 0 || policy.MaxBackoff < 0 {
+			return errors.New("retry policy backoff must not be negative")
+		}
+		if policy.Jitter < 0 || policy.Jitter > 1 {
+			return fmt.Errorf("retry policy jitter must be between 0 and 1, got %v", policy.Jitter)
+		}
+		k.RetryPolicy = policy
+		return nil
+	}
+}
+
+// WithAudioWait configures how AudioInference waits for the backend to generate audio.
+// Zero fields are set to the values of DefaultAudioWaitOptions.
+func WithAudioWait(opts AudioWaitOptions) Option {
+	return func(k *KindroidAI) error {
+		if opts.Strategy != AudioWaitPoll && opts.Strategy != AudioWaitSnapshot {
+			return fmt.Errorf("unknown audio wait strategy %d", opts.Strategy)
+		}
+		if opts.PollInterval < 0 || opts.MaxPollInterval < 0 || opts.MaxWait < 0 {
+			return errors.New("audio wait durations must not be negative")
+		}
+		if opts.MaxPollInterval > 0 && opts.MaxPollInterval < opts.PollInterval {
+			return fmt.Errorf("max poll interval %s must not be shorter than poll interval %s", opts.MaxPollInterval, opts.PollInterval)
+		}
+		k.AudioWait = &opts
+		return nil
+	}
+}
+
+// WithAudioCache stores downloaded audio in the given cache, so AudioInference and AudioStream
+// serve replays without downloading the audio again. See NewFileAudioCache.
+func WithAudioCache(cache AudioCache) Option {
+	return func(k *KindroidAI) error {
+		if cache == nil {
+			return errors.New("audio cache must not be nil")
+		}
+		k.AudioCache = cache
+		return nil
+	}
+}
+
+// WithRefreshToken enables automatic refreshing of the short-lived Firebase ID token used as API key.
+// The refresh token is exchanged for a new ID token at the secure token endpoint whenever the current
+// token is about to expire or a request is rejected as unauthorized. firebaseAPIKey is the Web API key
+// of the Firebase project, which the secure token endpoint requires.
+// If a refresh token is configured, the API key passed to New may be empty.
+func WithRefreshToken(refreshToken, firebaseAPIKey string) Option {
+	return func(k *KindroidAI) error {
+		if strings.TrimSpace(refreshToken) == "" {
+			return errors.New("refresh token must not be empty")
+		}
+		if k.TokenSource != nil {
+			return errors.New("refresh token cannot be combined with a token source")
+		}
+		k.refresher = &tokenRefresher{refreshToken: refreshToken, firebaseAPIKey: firebaseAPIKey}
+		return nil
+	}
+}
+
+// WithTokenRefreshURL overrides the endpoint used by WithRefreshToken, e.g. for test servers.
+func WithTokenRefreshURL(refreshURL string) Option {
+	return func(k *KindroidAI) error {
+		parsed, err := url.Parse(refreshURL)
+		if err != nil {
+			return fmt.Errorf("failed to parse token refresh URL: %w", err)
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return fmt.Errorf("token refresh URL must use http or https, got %q", refreshURL)
+		}
+		k.TokenRefreshURL = refreshURL
+		return nil
+	}
+}
+
+// WithTokenSource obtains the bearer token from the given source for every REST and Firestore request,
+// e.g. from a secret vault or a file rotated by another process (see FileTokenSource).
+// The API key passed to New is ignored and may be empty. Whether the token is a JWT, and thus whether
+// chat history and audio features are available, is derived from the token returned by the source.
+func WithTokenSource(source oauth2.TokenSource) Option {
+	return func(k *KindroidAI) error {
+		if source == nil {
+			return errors.New("token source must not be nil")
+		}
+		if k.refresher != nil {
+			return errors.New("token source cannot be combined with a refresh token")
+		}
+		k.TokenSource = source
+		return nil
+	}
+}
+
+// WithJWTVerification enables verification of JWT API keys before their user_id claim is trusted.
+// The signature is checked against the cached JSON Web Key Set, and issuer, audience and expiry are validated.
+// Empty fields of the config are set to DefaultJWKSURL, DefaultJWTAudience, DefaultJWTIssuer and DefaultJWKSCacheTTL.
+// With verification enabled, New fetches the key set and fails with *JWTVerificationError for invalid tokens.
+// Clients created with the same Option value share the key set cache.
+func WithJWTVerification(config JWTVerification) Option {
+	if config.JWKSURL == "" {
+		config.JWKSURL = DefaultJWKSURL
+	}
+	if config.Audience == "" {
+		config.Audience = DefaultJWTAudience
+	}
+	if config.Issuer == "" {
+		config.Issuer = DefaultJWTIssuer
+	}
+	if config.CacheTTL <= 0 {
+		config.CacheTTL = DefaultJWKSCacheTTL
+	}
+	verifier := &jwtVerifier{config: config}
+
+	return func(k *KindroidAI) error {
+		parsed, err := url.Parse(config.JWKSURL)
+		if err != nil {
+			return fmt.Errorf("failed to parse JWKS URL: %w", err)
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return fmt.Errorf("JWKS URL must use http or https, got %q", config.JWKSURL)
+		}
+		if config.Leeway < 0 {
+			return fmt.Errorf("JWT leeway must not be negative, got %s", config.Leeway)
+		}
+		k.verifier = verifier
+		return nil
+	}
+}
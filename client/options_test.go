@@ -0,0 +1,102 @@
+// Package client
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDefaults(t *testing.T) {
+	k, err := New("test_api_key")
+	require.NoError(t, err)
+	assert.Equal(t, "test_api_key", k.APIKey)
+	assert.Equal(t, DefaultBaseURL, k.BaseURL)
+	assert.Equal(t, DefaultFirestoreProject, k.FirestoreProject)
+	assert.NotNil(t, k.Client)
+	assert.Nil(t, k.RetryPolicy)
+
+	legacy := NewKindroidAI("test_api_key", "test_ai_id")
+	assert.Equal(t, "test_ai_id", legacy.KindroidID)
+	assert.Equal(t, DefaultBaseURL, legacy.BaseURL)
+}
+
+func TestNewWithOptions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "harmony-test/1.0", r.Header.Get("User-Agent"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	httpClient := &http.Client{}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	k, err := New("test_api_key",
+		WithKindroidID("test_ai_id"),
+		WithBaseURL(server.URL+"/"),
+		WithHTTPClient(httpClient),
+		WithTimeout(5*time.Second),
+		WithUserAgent("harmony-test/1.0"),
+		WithFirestoreProject("test-project"),
+		WithLogger(logger),
+		WithRetryPolicy(DefaultRetryPolicy()),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, server.URL, k.BaseURL, "trailing slash should be removed")
+	assert.Equal(t, 5*time.Second, k.Client.Timeout)
+	assert.Zero(t, httpClient.Timeout, "the supplied HTTP client must not be modified")
+	assert.Equal(t, "test-project", k.FirestoreProject)
+	assert.Same(t, logger, k.Logger)
+	assert.NotNil(t, k.RetryPolicy)
+
+	assert.NoError(t, k.ChatBreak("Hello again"))
+}
+
+func TestNewTimeoutBeforeHTTPClient(t *testing.T) {
+	k, err := New("test_api_key", WithTimeout(time.Second), WithHTTPClient(&http.Client{}))
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, k.Client.Timeout)
+}
+
+func TestNewInvalidOptions(t *testing.T) {
+	invalid := map[string]Option{
+		"base URL scheme":   WithBaseURL("ftp://example.com"),
+		"base URL host":     WithBaseURL("https://"),
+		"nil HTTP client":   WithHTTPClient(nil),
+		"negative timeout":  WithTimeout(-time.Second),
+		"empty user agent":  WithUserAgent(" "),
+		"empty project":     WithFirestoreProject(""),
+		"nil logger":        WithLogger(nil),
+		"nil retry policy":  WithRetryPolicy(nil),
+		"zero attempts":     WithRetryPolicy(&RetryPolicy{}),
+		"jitter over range": WithRetryPolicy(&RetryPolicy{MaxAttempts: 2, Jitter: 2}),
+	}
+	for name, opt := range invalid {
+		t.Run(name, func(t *testing.T) {
+			k, err := New("test_api_key", opt)
+			assert.Error(t, err)
+			assert.Nil(t, k)
+		})
+	}
+}
@@ -0,0 +1,152 @@
+// Package client
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Defaults used by SendMessageAndResolve for unset ResolveOptions fields.
+const (
+	DefaultResolveTimeout      = 30 * time.Second
+	DefaultResolvePollInterval = 500 * time.Millisecond
+	DefaultResolveClockSkew    = time.Minute
+)
+
+// Sender values of chat messages.
+const (
+	SenderUser = "user"
+	SenderAI   = "ai"
+)
+
+// ErrMessageNotResolved is returned if a sent message or its reply could not be located in the chat history in time.
+var ErrMessageNotResolved = errors.New("message could not be located in the chat history")
+
+// ResolveOptions controls how SendMessageAndResolve locates the exchanged messages in the chat history.
+type ResolveOptions struct {
+	// Timeout is the maximum time to wait for both messages to appear. Defaults to DefaultResolveTimeout.
+	Timeout time.Duration
+	// PollInterval is the delay between two chat history lookups. Defaults to DefaultResolvePollInterval.
+	PollInterval time.Duration
+	// ClockSkew is the tolerated difference between the local clock and the message timestamps.
+	// Only messages sent after the local send time minus ClockSkew are considered. Defaults to DefaultResolveClockSkew.
+	ClockSkew time.Duration
+}
+
+// ResolvedExchange is the result of SendMessageAndResolve.
+type ResolvedExchange struct {
+	// Result is the decoded response of the send-message endpoint.
+	Result *SendMessageResult
+	// UserMessage is the sent message as stored in the chat history.
+	UserMessage *ChatMessage
+	// Reply is the AI's reply as stored in the chat history.
+	Reply *ChatMessage
+}
+
+// SendMessageAndResolve sends a message and then locates the user's message and the AI's reply in the chat history,
+// so their message IDs can be used, e.g. for AudioInference.
+// Messages are matched by sender, timestamp and content. The chat history is polled until both messages are found,
+// the timeout expires or the context ends. If they cannot be located, the exchange found so far is returned along with
+// an error wrapping ErrMessageNotResolved.
+// Like GetChatHistory, this requires the client to be authenticated with a JWT.
+func (k *KindroidAI) SendMessageAndResolve(ctx context.Context, options SendMessageOptions, resolve ResolveOptions) (*ResolvedExchange, error) {
+	if _, err := k.checkFirestoreAccess(ctx, "resolving messages"); err != nil {
+		return nil, err
+	}
+	if options.AIID == "" {
+		options.AIID = k.KindroidID
+	}
+	timeout, pollInterval, clockSkew := resolve.Timeout, resolve.PollInterval, resolve.ClockSkew
+	if timeout <= 0 {
+		timeout = DefaultResolveTimeout
+	}
+	if pollInterval <= 0 {
+		pollInterval = DefaultResolvePollInterval
+	}
+	if clockSkew <= 0 {
+		clockSkew = DefaultResolveClockSkew
+	}
+
+	sentAt := time.Now()
+	body, err := k.sendMessage(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	exchange := &ResolvedExchange{Result: parseSendMessageResult(body)}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	listOptions := ListOptions{After: sentAt.Add(-clockSkew)}
+	for {
+		page, err := k.ListChatMessages(ctx, options.AIID, listOptions)
+		if err != nil && ctx.Err() == nil {
+			return exchange, fmt.Errorf("failed to resolve messages: %w", err)
+		}
+		if err == nil {
+			exchange.UserMessage, exchange.Reply = matchExchange(page.Messages, options.Message, exchange.Result.Text)
+			if exchange.UserMessage != nil && exchange.Reply != nil {
+				return exchange, nil
+			}
+		}
+
+		timer := time.NewTimer(pollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			missing := "user message"
+			if exchange.UserMessage != nil {
+				missing = "reply"
+			}
+			return exchange, fmt.Errorf("%w: %s not found: %w", ErrMessageNotResolved, missing, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// matchExchange finds the most recent user message with the given text and the AI's reply to it
+// in messages ordered newest first.
+// If the reply text is known, the reply must match it; otherwise the first AI message after the user message is used.
+func matchExchange(messages []*ChatMessage, sent, reply string) (*ChatMessage, *ChatMessage) {
+	sent, reply = strings.TrimSpace(sent), strings.TrimSpace(reply)
+
+	userIndex := -1
+	for i, msg := range messages {
+		if msg.Sender == SenderUser && strings.TrimSpace(msg.Message) == sent {
+			userIndex = i
+			break
+		}
+	}
+	if userIndex < 0 {
+		return nil, nil
+	}
+
+	// Messages before the user message in the slice were sent after it; walk them oldest first.
+	for i := userIndex - 1; i >= 0; i-- {
+		msg := messages[i]
+		if msg.Sender != SenderAI {
+			continue
+		}
+		if reply == "" || strings.TrimSpace(msg.Message) == reply {
+			return messages[userIndex], msg
+		}
+	}
+	return messages[userIndex], nil
+}
@@ -0,0 +1,112 @@
+// Package client
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendMessageAndResolve(t *testing.T) {
+	fake := testutil.NewFirestore(t, DefaultFirestoreProject)
+	now := time.Now().UnixMilli()
+
+	// An earlier exchange with the same text must not be picked up
+	fake.PutMessage(t, testUserID, testAIID, testutil.Message{ID: "old_user", Message: "Hello", Sender: SenderUser, Timestamp: now - 3600_000})
+	fake.PutMessage(t, testUserID, testAIID, testutil.Message{ID: "old_ai", Message: "Hi there!", Sender: SenderAI, Timestamp: now - 3599_000})
+
+	// The backend stores the user message immediately, while the reply document is written with a delay
+	var replyDelay atomic.Int64
+	replyDelay.Store(int64(300 * time.Millisecond))
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var options SendMessageOptions
+		json.NewDecoder(r.Body).Decode(&options)
+		sent := time.Now().UnixMilli()
+		fake.PutMessage(t, testUserID, options.AIID, testutil.Message{ID: "new_user", Message: options.Message, Sender: SenderUser, Timestamp: sent})
+		time.AfterFunc(time.Duration(replyDelay.Load()), func() {
+			// A proactive AI message may be stored in between
+			fake.PutMessage(t, testUserID, options.AIID, testutil.Message{ID: "other_ai", Message: "By the way...", Sender: SenderAI, Timestamp: sent + 1})
+			fake.PutMessage(t, testUserID, options.AIID, testutil.Message{ID: "new_ai", Message: "Hi there!", Sender: SenderAI, Timestamp: sent + 2})
+		})
+		w.Write([]byte(`"Hi there!"`))
+	}))
+	defer api.Close()
+
+	k, err := New(testutil.JWT(testUserID), WithKindroidID(testAIID), WithBaseURL(api.URL), WithFirestoreEmulator(fake.Addr))
+	require.NoError(t, err)
+	defer k.Close()
+
+	exchange, err := k.SendMessageAndResolve(context.Background(), SendMessageOptions{Message: "Hello"}, ResolveOptions{PollInterval: 50 * time.Millisecond})
+	require.NoError(t, err)
+	assert.Equal(t, "Hi there!", exchange.Result.Text)
+	assert.Equal(t, "new_user", exchange.UserMessage.ID)
+	assert.Equal(t, "Hello", exchange.UserMessage.Message)
+	assert.Equal(t, "new_ai", exchange.Reply.ID)
+	assert.Equal(t, "Hi there!", exchange.Reply.Message)
+
+	// If the reply never shows up, the partial exchange is returned
+	replyDelay.Store(int64(time.Hour))
+	exchange, err = k.SendMessageAndResolve(context.Background(), SendMessageOptions{Message: "Are you there?"},
+		ResolveOptions{Timeout: 200 * time.Millisecond, PollInterval: 50 * time.Millisecond})
+	assert.ErrorIs(t, err, ErrMessageNotResolved)
+	assert.ErrorContains(t, err, "reply not found")
+	require.NotNil(t, exchange)
+	assert.Equal(t, "Are you there?", exchange.UserMessage.Message)
+	assert.Nil(t, exchange.Reply)
+}
+
+func TestSendMessageAndResolveRequiresJWT(t *testing.T) {
+	k := NewKindroidAI("test_api_key", testAIID)
+	_, err := k.SendMessageAndResolve(context.Background(), SendMessageOptions{Message: "Hello"}, ResolveOptions{})
+	assert.ErrorIs(t, err, ErrJWTRequired)
+}
+
+func TestMatchExchange(t *testing.T) {
+	// Newest first, as returned by ListChatMessages
+	messages := []*ChatMessage{
+		{ID: "4", Sender: SenderAI, Message: "Second reply"},
+		{ID: "3", Sender: SenderUser, Message: "Hello"},
+		{ID: "2", Sender: SenderAI, Message: " First reply\n"},
+		{ID: "1", Sender: SenderUser, Message: "Hello"},
+	}
+
+	user, reply := matchExchange(messages, "Hello", "Second reply")
+	assert.Equal(t, "3", user.ID)
+	assert.Equal(t, "4", reply.ID)
+
+	// Without known reply text, the first AI message after the user message is used
+	user, reply = matchExchange(messages[1:], "Hello", "")
+	assert.Equal(t, "3", user.ID)
+	assert.Nil(t, reply)
+	user, reply = matchExchange(messages[2:], "Hello", "")
+	assert.Equal(t, "1", user.ID)
+	assert.Equal(t, "2", reply.ID)
+
+	user, reply = matchExchange(messages, "Goodbye", "")
+	assert.Nil(t, user)
+	assert.Nil(t, reply)
+}
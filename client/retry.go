@@ -0,0 +1,199 @@
+// Package client
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy controls how failed requests to the KindroidAI API are retried.
+//
+// Requests which deliver a message or change the chat state (send-message, chat-break) are
+// not idempotent and are only retried if RetryNonIdempotent is set. The exception is
+// HTTP 429, which means the request was rejected before being processed and is therefore
+// always safe to repeat.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one. Values below 2 disable retries.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between two attempts. It does not limit delays requested via Retry-After.
+	MaxBackoff time.Duration
+	// Multiplier is applied to the delay after every attempt.
+	Multiplier float64
+	// Jitter randomly reduces each delay by up to this fraction (0.0 - 1.0).
+	Jitter float64
+	// RetryableStatusCodes lists HTTP status codes which trigger a retry.
+	RetryableStatusCodes []int
+	// RetryNonIdempotent allows retrying requests which may have side effects, like sending a message.
+	// Enabling this can cause the same message to be delivered more than once.
+	RetryNonIdempotent bool
+}
+
+// DefaultRetryPolicy returns a RetryPolicy with sensible defaults:
+// 4 attempts, exponential backoff from 500ms up to 10s with 20% jitter, retrying on 429 and 5xx gateway errors.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		RetryableStatusCodes: []int{
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+	}
+}
+
+// backoff returns the delay before the given retry (1 for the first retry).
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	delay := float64(p.InitialBackoff)
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	for i := 1; i < retry; i++ {
+		delay *= multiplier
+		if p.MaxBackoff > 0 && delay >= float64(p.MaxBackoff) {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		delay -= delay * min(p.Jitter, 1) * rand.Float64()
+	}
+	return time.Duration(delay)
+}
+
+// shouldRetryStatus reports whether a response with the given status code may be retried.
+func (p *RetryPolicy) shouldRetryStatus(statusCode int, idempotent bool) bool {
+	if !slices.Contains(p.RetryableStatusCodes, statusCode) {
+		return false
+	}
+	return idempotent || p.RetryNonIdempotent || statusCode == http.StatusTooManyRequests
+}
+
+// shouldRetryError reports whether a transport error may be retried.
+// For non-idempotent requests it is unknown whether the server already processed the request,
+// so these are only retried if explicitly allowed.
+func (p *RetryPolicy) shouldRetryError(err error, idempotent bool) bool {
+	if !idempotent && !p.RetryNonIdempotent {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as an HTTP date.
+func parseRetryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
+
+// do executes the request, retrying according to the client's RetryPolicy.
+// It returns the response only if the API responded with HTTP 200; any other status is returned as *APIError.
+// A request rejected with HTTP 401 is repeated once if a refreshed or rotated token is available.
+// The request body must be rewindable, which is the case for requests created by newRequest.
+func (k *KindroidAI) do(req *http.Request, endpoint string, idempotent bool) (*http.Response, error) {
+	policy := k.RetryPolicy
+	sent, reauthenticated := false, false
+	for attempt := 1; ; attempt++ {
+		if sent && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		sent = true
+
+		var retryAfter time.Duration
+		var retryable bool
+		resp, err := k.Client.Do(req)
+		if err == nil {
+			if resp.StatusCode == http.StatusOK {
+				return resp, nil
+			}
+			err = newAPIError(resp, endpoint)
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusUnauthorized && !reauthenticated {
+				reauthenticated = true
+				rejected := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+				token, renewed, errRenew := k.renewToken(req.Context(), rejected)
+				if errRenew != nil {
+					return nil, fmt.Errorf("%w (%w)", err, errRenew)
+				}
+				if renewed {
+					k.logger().Debug("repeating request with renewed token", "endpoint", endpoint)
+					req.Header.Set("Authorization", "Bearer "+token)
+					attempt--
+					continue
+				}
+			}
+			if policy != nil {
+				retryable = policy.shouldRetryStatus(resp.StatusCode, idempotent)
+				retryAfter = parseRetryAfter(resp.Header)
+			}
+		} else if policy != nil {
+			retryable = policy.shouldRetryError(err, idempotent)
+		}
+
+		if !retryable || attempt >= policy.MaxAttempts {
+			return nil, err
+		}
+
+		delay := max(policy.backoff(attempt), retryAfter)
+		k.logger().Debug("retrying request", "endpoint", endpoint, "attempt", attempt, "delay", delay, "error", err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Package client
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFlakyServer returns a server which fails the first `failures` requests with the given status.
+func newFlakyServer(t *testing.T, failures int32, status int, header http.Header) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.NotEmpty(t, body, "request body must be resent on every attempt")
+
+		if calls.Add(1) <= failures {
+			for key, values := range header {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(status)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"uid":"test_uid","status":"OK"}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func testRetryPolicy() *RetryPolicy {
+	policy := DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	policy.MaxBackoff = 5 * time.Millisecond
+	return policy
+}
+
+func TestRetryIdempotentRequest(t *testing.T) {
+	server, calls := newFlakyServer(t, 2, http.StatusServiceUnavailable, nil)
+	k := NewKindroidAI("test_api_key", "test_ai_id")
+	k.BaseURL = server.URL
+	k.RetryPolicy = testRetryPolicy()
+
+	subInfo, err := k.CheckUserSubscription()
+	require.NoError(t, err)
+	assert.Equal(t, "test_uid", subInfo.UID)
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	server, calls := newFlakyServer(t, 10, http.StatusBadGateway, nil)
+	k := NewKindroidAI("test_api_key", "test_ai_id")
+	k.BaseURL = server.URL
+	k.RetryPolicy = testRetryPolicy()
+
+	_, err := k.CheckUserSubscription()
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusBadGateway, apiErr.StatusCode)
+	assert.Equal(t, int32(4), calls.Load())
+}
+
+func TestRetryNonIdempotentRequest(t *testing.T) {
+	server, calls := newFlakyServer(t, 1, http.StatusServiceUnavailable, nil)
+	k := NewKindroidAI("test_api_key", "test_ai_id")
+	k.BaseURL = server.URL
+	k.RetryPolicy = testRetryPolicy()
+
+	// Sends are not retried by default
+	_, err := k.SendMessage("Hello")
+	require.Error(t, err)
+	assert.Equal(t, int32(1), calls.Load())
+
+	// ...unless explicitly allowed
+	calls.Store(0)
+	k.RetryPolicy.RetryNonIdempotent = true
+	_, err = k.SendMessage("Hello")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestRetryRateLimitedSend(t *testing.T) {
+	header := http.Header{"Retry-After": []string{"1"}}
+	server, calls := newFlakyServer(t, 1, http.StatusTooManyRequests, header)
+	k := NewKindroidAI("test_api_key", "test_ai_id")
+	k.BaseURL = server.URL
+	k.RetryPolicy = testRetryPolicy()
+
+	// A 429 means the message was not processed, so it is retried even for sends
+	start := time.Now()
+	_, err := k.SendMessage("Hello")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), calls.Load())
+	assert.GreaterOrEqual(t, time.Since(start), time.Second, "Retry-After must be respected")
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := &RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}
+	assert.Equal(t, 100*time.Millisecond, policy.backoff(1))
+	assert.Equal(t, 200*time.Millisecond, policy.backoff(2))
+	assert.Equal(t, 400*time.Millisecond, policy.backoff(3))
+	assert.Equal(t, time.Second, policy.backoff(10))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay := policy.backoff(1)
+		assert.GreaterOrEqual(t, delay, 50*time.Millisecond)
+		assert.LessOrEqual(t, delay, 100*time.Millisecond)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert.Equal(t, 3*time.Second, parseRetryAfter(http.Header{"Retry-After": []string{"3"}}))
+	assert.Zero(t, parseRetryAfter(http.Header{}))
+	assert.Zero(t, parseRetryAfter(http.Header{"Retry-After": []string{"invalid"}}))
+
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	delay := parseRetryAfter(http.Header{"Retry-After": []string{date}})
+	assert.InDelta(t, float64(time.Minute), float64(delay), float64(2*time.Second))
+}
@@ -0,0 +1,221 @@
+// Package client
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// streamReadSize is the maximum number of bytes read from a raw chunked response per chunk.
+const streamReadSize = 4096
+
+// MessageStream provides incremental access to a streamed send-message response.
+//
+// Usage follows the bufio.Scanner pattern:
+//
+//	for stream.Next() {
+//		fmt.Print(stream.Chunk())
+//	}
+//	if err := stream.Err(); err != nil { ... }
+//	full := stream.Message()
+//
+// The stream must be closed once it is no longer needed.
+type MessageStream struct {
+	body    io.ReadCloser
+	reader  *bufio.Reader
+	sse     bool
+	isJSON  bool   // the body is a complete JSON reply rather than a stream
+	pending []byte // incomplete UTF-8 sequence carried over between raw chunks
+	chunk   string
+	message strings.Builder
+	err     error
+	done    bool
+}
+
+// SendMessageStream sends a message to the AI and streams the response as it is generated.
+// The Stream flag of the given options is always enabled. Both Server-Sent Events and plain
+// chunked text responses are supported. A JSON response, e.g. from a backend which ignores the
+// Stream flag, is decoded like a SendMessage reply and produces a single chunk. Cancelling ctx
+// aborts the stream.
+func (k *KindroidAI) SendMessageStream(ctx context.Context, options SendMessageOptions) (*MessageStream, error) {
+	options.Stream = true
+
+	jsonData, err := json.Marshal(options)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := k.newRequest(ctx, "send-message", jsonData)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream, text/plain")
+
+	resp, err := k.do(req, "send-message", false)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMessageStream(resp), nil
+}
+
+func newMessageStream(resp *http.Response) *MessageStream {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return &MessageStream{
+		body:   resp.Body,
+		reader: bufio.NewReaderSize(resp.Body, streamReadSize),
+		sse:    mediaType == "text/event-stream",
+		isJSON: mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"),
+	}
+}
+
+// Next advances the stream to the next chunk of text, which is then available through Chunk.
+// It returns false when the stream ends, either because the response is complete or
+// because an error occurred. After Next returns false, Err reports any error.
+func (s *MessageStream) Next() bool {
+	if s.done {
+		return false
+	}
+
+	var chunk string
+	var err error
+	switch {
+	case s.sse:
+		chunk, err = s.nextEvent()
+	case s.isJSON:
+		chunk, err = s.nextJSON()
+	default:
+		chunk, err = s.nextRaw()
+	}
+	if err != nil {
+		s.done = true
+		if !errors.Is(err, io.EOF) {
+			s.err = fmt.Errorf("failed to read message stream: %w", err)
+		}
+		// Flush a trailing chunk that was read together with the end of the stream.
+		if chunk == "" {
+			return false
+		}
+	}
+
+	s.chunk = chunk
+	s.message.WriteString(chunk)
+	return true
+}
+
+// nextRaw reads the next block of plain text from the response body.
+// It never splits a multibyte UTF-8 character between two chunks.
+func (s *MessageStream) nextRaw() (string, error) {
+	buf := make([]byte, streamReadSize)
+	for {
+		n, err := s.reader.Read(buf)
+		data := append(s.pending, buf[:n]...)
+		s.pending = nil
+
+		if err != nil {
+			// Return whatever is left, even if it is not valid UTF-8.
+			return string(data), err
+		}
+
+		// Hold back an incomplete UTF-8 sequence at the end of the data.
+		cut := len(data)
+		for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+			if utf8.RuneStart(data[i]) {
+				if !utf8.FullRune(data[i:]) {
+					cut = i
+				}
+				break
+			}
+		}
+		s.pending = append([]byte(nil), data[cut:]...)
+		if cut > 0 {
+			return string(data[:cut]), nil
+		}
+	}
+}
+
+// nextJSON reads the whole JSON body and returns the decoded reply text as the only chunk.
+func (s *MessageStream) nextJSON() (string, error) {
+	body, err := io.ReadAll(s.reader)
+	if err != nil {
+		return "", err
+	}
+	return parseSendMessageResult(body).Text, io.EOF
+}
+
+// nextEvent reads Server-Sent Events until one with a non-empty data payload is found.
+// A "[DONE]" payload terminates the stream.
+func (s *MessageStream) nextEvent() (string, error) {
+	var data []string
+	for {
+		line, err := s.reader.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+
+		if line == "" {
+			if len(data) > 0 {
+				payload := strings.Join(data, "\n")
+				if payload == "[DONE]" {
+					return "", io.EOF
+				}
+				return payload, nil
+			}
+		} else if strings.HasPrefix(line, "data:") {
+			value := strings.TrimPrefix(line, "data:")
+			data = append(data, strings.TrimPrefix(value, " "))
+		}
+		// Comments and other SSE fields (event, id, retry) carry no text and are ignored.
+
+		if err != nil {
+			payload := strings.Join(data, "\n")
+			if payload == "[DONE]" {
+				payload = ""
+			}
+			return payload, err
+		}
+	}
+}
+
+// Chunk returns the most recent chunk of text produced by Next.
+func (s *MessageStream) Chunk() string {
+	return s.chunk
+}
+
+// Message returns the aggregated text of all chunks received so far.
+// Once Next returned false without error, this is the complete AI response.
+func (s *MessageStream) Message() string {
+	return s.message.String()
+}
+
+// Err returns the first error encountered while reading the stream, if any.
+func (s *MessageStream) Err() error {
+	return s.err
+}
+
+// Close releases the underlying HTTP response.
+func (s *MessageStream) Close() error {
+	s.done = true
+	return s.body.Close()
+}
@@ -0,0 +1,181 @@
+// Package client
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MessageStreamTestSuite struct {
+	suite.Suite
+	Server *httptest.Server
+	Client *KindroidAI
+}
+
+func (suite *MessageStreamTestSuite) SetupTest() {
+	suite.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.Equal("/send-message", r.URL.Path)
+		suite.Equal("Bearer test_api_key", r.Header.Get("Authorization"), "Invalid Authorization header")
+
+		var options SendMessageOptions
+		suite.NoError(json.NewDecoder(r.Body).Decode(&options))
+		suite.True(options.Stream, "Stream flag must be set for streaming requests")
+
+		flusher := w.(http.Flusher)
+		switch options.Message {
+		case "raw":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			// "ü" is split across two writes to verify UTF-8 handling
+			for _, chunk := range [][]byte{[]byte("Hello"), []byte(", Gr\xc3"), []byte("\xbc\xc3\x9fe!")} {
+				w.Write(chunk)
+				flusher.Flush()
+				time.Sleep(20 * time.Millisecond)
+			}
+
+		case "sse":
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.WriteHeader(http.StatusOK)
+			for _, event := range []string{": keep-alive\n\n", "data: Hello\n\n", "event: message\ndata: , \ndata: user\n\n", "data: [DONE]\n\n"} {
+				w.Write([]byte(event))
+				flusher.Flush()
+				time.Sleep(20 * time.Millisecond)
+			}
+
+		case "json":
+			// A backend which ignores the Stream flag answers with a JSON-quoted string
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`"Hello, \"user\"!\nHow are you?"`))
+
+		case "slow":
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("first"))
+			flusher.Flush()
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+
+		case "broken":
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("partial"))
+			flusher.Flush()
+			// Drop the connection without terminating the chunked body
+			conn, _, err := w.(http.Hijacker).Hijack()
+			suite.NoError(err)
+			conn.Close()
+
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+
+	suite.Client = NewKindroidAI("test_api_key", "test_ai_id")
+	suite.Client.BaseURL = suite.Server.URL
+}
+
+func (suite *MessageStreamTestSuite) TearDownTest() {
+	suite.Server.Close()
+}
+
+func (suite *MessageStreamTestSuite) collect(stream *MessageStream) []string {
+	var chunks []string
+	for stream.Next() {
+		chunks = append(chunks, stream.Chunk())
+	}
+	return chunks
+}
+
+func (suite *MessageStreamTestSuite) TestRawChunks() {
+	stream, err := suite.Client.SendMessageStream(context.Background(), SendMessageOptions{AIID: "test_ai_id", Message: "raw"})
+	suite.Require().NoError(err)
+	defer stream.Close()
+
+	chunks := suite.collect(stream)
+	suite.NoError(stream.Err())
+	suite.Equal([]string{"Hello", ", Gr", "üße!"}, chunks)
+	suite.Equal("Hello, Grüße!", stream.Message())
+}
+
+func (suite *MessageStreamTestSuite) TestServerSentEvents() {
+	stream, err := suite.Client.SendMessageStream(context.Background(), SendMessageOptions{AIID: "test_ai_id", Message: "sse"})
+	suite.Require().NoError(err)
+	defer stream.Close()
+
+	chunks := suite.collect(stream)
+	suite.NoError(stream.Err())
+	suite.Equal([]string{"Hello", ", \nuser"}, chunks)
+	suite.Equal("Hello, \nuser", stream.Message())
+}
+
+func (suite *MessageStreamTestSuite) TestJSONReply() {
+	stream, err := suite.Client.SendMessageStream(context.Background(), SendMessageOptions{AIID: "test_ai_id", Message: "json"})
+	suite.Require().NoError(err)
+	defer stream.Close()
+
+	chunks := suite.collect(stream)
+	suite.NoError(stream.Err())
+	suite.Equal([]string{"Hello, \"user\"!\nHow are you?"}, chunks)
+	suite.Equal("Hello, \"user\"!\nHow are you?", stream.Message())
+}
+
+func (suite *MessageStreamTestSuite) TestCancellation() {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := suite.Client.SendMessageStream(ctx, SendMessageOptions{AIID: "test_ai_id", Message: "slow"})
+	suite.Require().NoError(err)
+	defer stream.Close()
+
+	suite.True(stream.Next())
+	suite.Equal("first", stream.Chunk())
+
+	cancel()
+	suite.False(stream.Next())
+	suite.ErrorIs(stream.Err(), context.Canceled)
+	suite.Equal("first", stream.Message())
+}
+
+func (suite *MessageStreamTestSuite) TestMidStreamError() {
+	stream, err := suite.Client.SendMessageStream(context.Background(), SendMessageOptions{AIID: "test_ai_id", Message: "broken"})
+	suite.Require().NoError(err)
+	defer stream.Close()
+
+	chunks := suite.collect(stream)
+	suite.Error(stream.Err())
+	suite.Equal([]string{"partial"}, chunks)
+	suite.Equal("partial", stream.Message())
+}
+
+func (suite *MessageStreamTestSuite) TestHTTPError() {
+	stream, err := suite.Client.SendMessageStream(context.Background(), SendMessageOptions{AIID: "test_ai_id", Message: "fail"})
+	suite.Error(err)
+	suite.Nil(stream)
+}
+
+func TestMessageStreamTestSuite(t *testing.T) {
+	suite.Run(t, new(MessageStreamTestSuite))
+}
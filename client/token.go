@@ -0,0 +1,309 @@
+// Package client
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// DefaultTokenRefreshURL is the Firebase secure token endpoint used to exchange refresh tokens for ID tokens.
+const DefaultTokenRefreshURL = "https://securetoken.googleapis.com/v1/token"
+
+// tokenRefreshMargin is how long before its expiry an ID token is refreshed.
+const tokenRefreshMargin = 5 * time.Minute
+
+// tokenRefresher exchanges a Firebase refresh token for short-lived ID tokens.
+// It is safe for concurrent use.
+type tokenRefresher struct {
+	refreshToken   string
+	firebaseAPIKey string
+
+	mu        sync.Mutex
+	idToken   string
+	expiresAt time.Time
+}
+
+// refreshResponse is the response of the secure token endpoint.
+type refreshResponse struct {
+	IDToken      string `json:"id_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    string `json:"expires_in"`
+}
+
+// parseJWT parses the claims of a JWT without verifying its signature.
+// A "Bearer " prefix is ignored.
+func parseJWT(tokenString string) (jwt.MapClaims, error) {
+	tokenString = strings.TrimPrefix(tokenString, "Bearer ")
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, claims); err != nil {
+		return nil, fmt.Errorf("failed to parse JWT: %w", err)
+	}
+	return claims, nil
+}
+
+// jwtExpiry returns the expiry of the given JWT, or the zero time if it is not a JWT or does not expire.
+func jwtExpiry(tokenString string) time.Time {
+	claims, err := parseJWT(tokenString)
+	if err != nil {
+		return time.Time{}
+	}
+	exp, err := claims.GetExpirationTime()
+	if err != nil || exp == nil {
+		return time.Time{}
+	}
+	return exp.Time
+}
+
+// currentToken returns the bearer token currently in use without refreshing it.
+// The empty string is returned if the configured TokenSource fails.
+func (k *KindroidAI) currentToken() string {
+	if k.TokenSource != nil {
+		token, err := k.TokenSource.Token()
+		if err != nil {
+			return ""
+		}
+		return token.AccessToken
+	}
+	if r := k.refresher; r != nil {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.idToken != "" {
+			return r.idToken
+		}
+	}
+	return k.APIKey
+}
+
+// token returns the credentials for the next request.
+// The token is taken from the TokenSource if configured, along with the JWTAuth and UserID derived from it.
+// Otherwise, if a refresh token is configured, the ID token is refreshed first if it is missing or about to expire,
+// and otherwise the APIKey is used; both come with the UserID and JWTAuth fields of the client.
+func (k *KindroidAI) token(ctx context.Context) (*credentials, error) {
+	if k.TokenSource != nil {
+		token, err := k.TokenSource.Token()
+		if err != nil {
+			return nil, fmt.Errorf("failed to obtain token: %w", err)
+		}
+		if token.AccessToken == "" {
+			return nil, errors.New("failed to obtain token: token source returned an empty token")
+		}
+		return k.deriveFromToken(ctx, token.AccessToken)
+	}
+	token := k.APIKey
+	if k.refresher != nil {
+		var err error
+		if token, err = k.refreshIDToken(ctx, false); err != nil {
+			return nil, err
+		}
+	}
+	return k.fieldCredentials(token), nil
+}
+
+// fieldCredentials returns the given token with the UserID and JWTAuth fields of the client.
+func (k *KindroidAI) fieldCredentials(token string) *credentials {
+	return &credentials{token: token, userID: k.UserID, source: k.UserIDSource, jwtAuth: k.JWTAuth}
+}
+
+// deriveFromToken returns the credentials derived from a TokenSource token.
+// They are derived again whenever the source returns a different token than before. Deriving may verify the
+// token against the signing keys fetched over the network, so no lock is held meanwhile; requests using the
+// previously derived token are not held up, and concurrent requests may derive the same token twice.
+func (k *KindroidAI) deriveFromToken(ctx context.Context, token string) (*credentials, error) {
+	if derived := k.derived.Load(); derived != nil && derived.token == token {
+		return derived, nil
+	}
+	creds, err := k.deriveCredentials(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain token: %w", err)
+	}
+	k.derived.Store(creds)
+	return creds, nil
+}
+
+// renewToken obtains a new token after the given token was rejected by the API.
+// It reports false if no different token is available.
+func (k *KindroidAI) renewToken(ctx context.Context, rejected string) (string, bool, error) {
+	switch {
+	case k.refresher != nil:
+		token, err := k.refreshIDToken(ctx, true)
+		return token, err == nil, err
+	case k.TokenSource != nil:
+		// The source may have rotated the token in the meantime
+		creds, err := k.token(ctx)
+		if err != nil {
+			return "", false, err
+		}
+		return creds.token, creds.token != rejected, nil
+	default:
+		return "", false, nil
+	}
+}
+
+// refreshIDToken returns a valid ID token, exchanging the refresh token for a new one if the current token
+// expires within tokenRefreshMargin or force is set.
+func (k *KindroidAI) refreshIDToken(ctx context.Context, force bool) (string, error) {
+	r := k.refresher
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.idToken == "" && k.APIKey != "" {
+		// Start with the API key if it is a usable ID token
+		r.idToken, r.expiresAt = k.APIKey, jwtExpiry(k.APIKey)
+	}
+	if !force && !r.expiresAt.IsZero() && time.Until(r.expiresAt) > tokenRefreshMargin {
+		return r.idToken, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {r.refreshToken},
+	}
+	endpoint := k.tokenRefreshURL()
+	if r.firebaseAPIKey != "" {
+		endpoint += "?key=" + url.QueryEscape(r.firebaseAPIKey)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to refresh ID token: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if k.UserAgent != "" {
+		req.Header.Set("User-Agent", k.UserAgent)
+	}
+
+	resp, err := k.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to refresh ID token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to refresh ID token: %w", newAPIError(resp, "token"))
+	}
+
+	var result refreshResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode token refresh response: %w", err)
+	}
+	if result.IDToken == "" {
+		return "", errors.New("failed to refresh ID token: response does not contain an ID token")
+	}
+
+	r.idToken = result.IDToken
+	r.expiresAt = jwtExpiry(result.IDToken)
+	if r.expiresAt.IsZero() {
+		if seconds, err := strconv.Atoi(result.ExpiresIn); err == nil {
+			r.expiresAt = time.Now().Add(time.Duration(seconds) * time.Second)
+		}
+	}
+	if result.RefreshToken != "" {
+		// Refresh tokens may be rotated by the server
+		r.refreshToken = result.RefreshToken
+	}
+	k.logger().Debug("refreshed ID token", "expires_at", r.expiresAt)
+	return r.idToken, nil
+}
+
+// tokenRefreshURL returns the configured token refresh URL or the default URL.
+func (k *KindroidAI) tokenRefreshURL() string {
+	if k.TokenRefreshURL != "" {
+		return k.TokenRefreshURL
+	}
+	return DefaultTokenRefreshURL
+}
+
+// TokenExpiresAt returns the expiry of the current ID token.
+// If the token is not a JWT, the expiry reported by the TokenSource is used.
+// The zero time is returned if the expiry is unknown.
+func (k *KindroidAI) TokenExpiresAt() time.Time {
+	if expiresAt := jwtExpiry(k.currentToken()); !expiresAt.IsZero() {
+		return expiresAt
+	}
+	if k.TokenSource != nil {
+		if token, err := k.TokenSource.Token(); err == nil {
+			return token.Expiry
+		}
+	}
+	return time.Time{}
+}
+
+// clientTokenSource provides the client's current bearer token to the Firestore client,
+// so refreshed ID tokens are used without rebuilding the Firestore client.
+type clientTokenSource struct {
+	k *KindroidAI
+}
+
+func (s clientTokenSource) Token() (*oauth2.Token, error) {
+	creds, err := s.k.token(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &oauth2.Token{AccessToken: creds.token, TokenType: "Bearer", Expiry: jwtExpiry(creds.token)}, nil
+}
+
+// fileTokenSource reads the bearer token from a file.
+type fileTokenSource struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	token   *oauth2.Token
+}
+
+// FileTokenSource returns a TokenSource which reads the bearer token from the given file,
+// e.g. a file that is rotated by another process. The file is read again whenever it was modified.
+// Leading and trailing whitespace is ignored.
+func FileTokenSource(path string) oauth2.TokenSource {
+	return &fileTokenSource{path: path}
+}
+
+func (s *fileTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token file: %w", err)
+	}
+	if s.token != nil && info.ModTime().Equal(s.modTime) {
+		return s.token, nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token file: %w", err)
+	}
+	accessToken := strings.TrimSpace(string(data))
+	if accessToken == "" {
+		return nil, fmt.Errorf("token file %s is empty", s.path)
+	}
+	s.token = &oauth2.Token{AccessToken: accessToken, TokenType: "Bearer", Expiry: jwtExpiry(accessToken)}
+	s.modTime = info.ModTime()
+	return s.token, nil
+}
@@ -0,0 +1,456 @@
+// Package client
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func testJWTExpiring(userID string, exp time.Time) string {
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": userID,
+		"exp":     exp.Unix(),
+	}).SignedString([]byte("test"))
+	return token
+}
+
+// fakeSecureToken is a stand-in for the Firebase secure token endpoint.
+type fakeSecureToken struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	issued   []string
+	lifetime time.Duration
+	fail     bool
+}
+
+func newFakeSecureToken(t *testing.T) *fakeSecureToken {
+	f := &fakeSecureToken{lifetime: time.Hour}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "web_api_key", r.URL.Query().Get("key"))
+		assert.Equal(t, "refresh_token", r.FormValue("grant_type"))
+		if f.fail || r.FormValue("refresh_token") != fmt.Sprintf("refresh_%d", len(f.issued)) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"message":"INVALID_REFRESH_TOKEN"}}`))
+			return
+		}
+
+		idToken := testJWTExpiring(testUserID, time.Now().Add(f.lifetime))
+		f.issued = append(f.issued, idToken)
+		json.NewEncoder(w).Encode(map[string]string{
+			"id_token":      idToken,
+			"refresh_token": fmt.Sprintf("refresh_%d", len(f.issued)),
+			"expires_in":    "3600",
+			"user_id":       testUserID,
+		})
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeSecureToken) setLifetime(lifetime time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lifetime = lifetime
+}
+
+func (f *fakeSecureToken) setFail(fail bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fail = fail
+}
+
+func (f *fakeSecureToken) issuedTokens() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.issued...)
+}
+
+func TestTokenExpiresAt(t *testing.T) {
+	exp := time.Unix(1900000000, 0)
+	k := NewKindroidAI(testJWTExpiring(testUserID, exp), testAIID)
+	assert.True(t, exp.Equal(k.TokenExpiresAt()))
+
+	userID, expiresAt, err := k.extractUserIDFromJWT()
+	require.NoError(t, err)
+	assert.Equal(t, testUserID, userID)
+	assert.True(t, exp.Equal(expiresAt))
+
+	// Tokens without exp claim and static API keys have no known expiry
+	assert.True(t, NewKindroidAI(testutil.JWT(testUserID), testAIID).TokenExpiresAt().IsZero())
+	assert.True(t, NewKindroidAI("test_api_key", testAIID).TokenExpiresAt().IsZero())
+}
+
+func TestTokenRefresh(t *testing.T) {
+	secureToken := newFakeSecureToken(t)
+	var authHeaders []string
+	var mu sync.Mutex
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		authHeaders = append(authHeaders, r.Header.Get("Authorization"))
+		mu.Unlock()
+		w.Write([]byte("reply"))
+	}))
+	defer api.Close()
+
+	expired := testJWTExpiring(testUserID, time.Now().Add(-time.Minute))
+	k, err := New(expired,
+		WithKindroidID(testAIID),
+		WithBaseURL(api.URL),
+		WithRefreshToken("refresh_0", "web_api_key"),
+		WithTokenRefreshURL(secureToken.URL),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, testUserID, k.UserID)
+
+	// The expired token is refreshed before the first request; the fresh token is reused afterwards
+	for range 2 {
+		_, err = k.SendMessage("hello")
+		require.NoError(t, err)
+	}
+	issued := secureToken.issuedTokens()
+	require.Len(t, issued, 1)
+	assert.Equal(t, []string{"Bearer " + issued[0], "Bearer " + issued[0]}, authHeaders)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), k.TokenExpiresAt(), time.Minute)
+
+	// Tokens close to expiry are refreshed using the rotated refresh token
+	secureToken.setLifetime(time.Minute)
+	_, err = k.refreshIDToken(context.Background(), true)
+	require.NoError(t, err)
+	_, err = k.SendMessage("hello")
+	require.NoError(t, err)
+	assert.Len(t, secureToken.issuedTokens(), 3)
+}
+
+func TestTokenRefreshOnUnauthorized(t *testing.T) {
+	secureToken := newFakeSecureToken(t)
+	stale := testJWTExpiring(testUserID, time.Now().Add(2*time.Hour))
+	var bodies []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var opts SendMessageOptions
+		json.NewDecoder(r.Body).Decode(&opts)
+		bodies = append(bodies, opts.Message)
+		if r.Header.Get("Authorization") == "Bearer "+stale {
+			http.Error(w, "token revoked", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("reply"))
+	}))
+	defer api.Close()
+
+	k, err := New(stale,
+		WithKindroidID(testAIID),
+		WithBaseURL(api.URL),
+		WithRefreshToken("refresh_0", "web_api_key"),
+		WithTokenRefreshURL(secureToken.URL),
+	)
+	require.NoError(t, err)
+
+	response, err := k.SendMessage("hello")
+	require.NoError(t, err)
+	assert.Equal(t, "reply", response)
+	assert.Equal(t, []string{"hello", "hello"}, bodies, "the request body must be sent again")
+	assert.Len(t, secureToken.issuedTokens(), 1)
+
+	// If refreshing fails, the original error is still reported
+	secureToken.setFail(true)
+	k.refresher.idToken = stale
+	_, err = k.SendMessage("hello")
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	var apiErr *APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.True(t, strings.Contains(err.Error(), "INVALID_REFRESH_TOKEN"))
+}
+
+func TestTokenRefreshFirestore(t *testing.T) {
+	secureToken := newFakeSecureToken(t)
+	fake := testutil.NewFirestore(t, DefaultFirestoreProject)
+	fake.PutMessage(t, testUserID, testAIID, testutil.Message{ID: "msg", Message: "hello", Sender: "ai", Timestamp: 1000})
+
+	// Only a refresh token is available; the ID token is obtained by SetupUserAndPermissions
+	k, err := New("",
+		WithKindroidID(testAIID),
+		WithFirestoreEmulator(fake.Addr),
+		WithRefreshToken("refresh_0", "web_api_key"),
+		WithTokenRefreshURL(secureToken.URL),
+	)
+	require.NoError(t, err)
+	defer k.Close()
+	assert.False(t, k.JWTAuth)
+	require.NoError(t, k.SetupUserAndPermissions())
+	assert.True(t, k.JWTAuth)
+	assert.Equal(t, UserIDSourceJWT, k.UserIDSource)
+
+	_, err = k.GetMessageById(context.Background(), testAIID, "msg")
+	require.NoError(t, err)
+
+	// A refreshed token is used by the existing Firestore client
+	client, release, err := k.firestoreClient(context.Background())
+	require.NoError(t, err)
+	release()
+	_, err = k.refreshIDToken(context.Background(), true)
+	require.NoError(t, err)
+	_, err = k.GetMessageById(context.Background(), testAIID, "msg")
+	require.NoError(t, err)
+	same, release, err := k.firestoreClient(context.Background())
+	require.NoError(t, err)
+	release()
+	assert.Same(t, client, same)
+
+	issued := secureToken.issuedTokens()
+	require.Len(t, issued, 2)
+	assert.Contains(t, fake.Tokens(), issued[0])
+	assert.Contains(t, fake.Tokens(), issued[1])
+}
+
+func TestTokenRefreshFailure(t *testing.T) {
+	secureToken := newFakeSecureToken(t)
+	secureToken.setFail(true)
+
+	k, err := New("", WithRefreshToken("refresh_0", "web_api_key"), WithTokenRefreshURL(secureToken.URL))
+	require.NoError(t, err)
+	err = k.SetupUserAndPermissions()
+	var apiErr *APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+
+	_, err = New("", WithRefreshToken(" ", "web_api_key"))
+	assert.Error(t, err)
+	_, err = New("", WithTokenRefreshURL("ftp://example.com"))
+	assert.Error(t, err)
+}
+
+// rotatingTokenSource is a TokenSource whose token can be replaced by the test.
+type rotatingTokenSource struct {
+	mu    sync.Mutex
+	token string
+}
+
+func (s *rotatingTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == "" {
+		return nil, errors.New("vault unavailable")
+	}
+	return &oauth2.Token{AccessToken: s.token}, nil
+}
+
+func (s *rotatingTokenSource) rotate(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = token
+}
+
+// sequenceTokenSource returns the given tokens in order, one per call.
+type sequenceTokenSource struct {
+	tokens []string
+}
+
+func (s *sequenceTokenSource) Token() (*oauth2.Token, error) {
+	token := s.tokens[0]
+	if len(s.tokens) > 1 {
+		s.tokens = s.tokens[1:]
+	}
+	return &oauth2.Token{AccessToken: token}, nil
+}
+
+func TestTokenSource(t *testing.T) {
+	first := testJWTExpiring(testUserID, time.Now().Add(time.Hour))
+	second := testJWTExpiring(testUserID, time.Now().Add(2*time.Hour))
+	source := &rotatingTokenSource{token: first}
+
+	var authHeaders []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeaders = append(authHeaders, r.Header.Get("Authorization"))
+		// Only the most recent token is accepted
+		if r.Header.Get("Authorization") != "Bearer "+second {
+			http.Error(w, "expired", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("reply"))
+	}))
+	defer api.Close()
+
+	k, err := New("", WithKindroidID(testAIID), WithBaseURL(api.URL), WithTokenSource(source))
+	require.NoError(t, err)
+	assert.True(t, k.JWTAuth, "JWT authentication is derived from the token source")
+	assert.Equal(t, testUserID, k.UserID)
+
+	// The rejected token is not repeated if the source has no newer token
+	_, err = k.SendMessage("hello")
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	assert.Len(t, authHeaders, 1)
+
+	// The token is fetched for every request, so rotations are picked up
+	source.rotate(second)
+	_, err = k.SendMessage("hello")
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer "+second, authHeaders[len(authHeaders)-1])
+
+	// A request rejected with the old token is repeated with the rotated one
+	authHeaders = nil
+	k.TokenSource = &sequenceTokenSource{tokens: []string{first, second}}
+	_, err = k.SendMessage("hello")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Bearer " + first, "Bearer " + second}, authHeaders)
+
+	// Errors of the source are reported
+	k.TokenSource = &rotatingTokenSource{}
+	_, err = k.SendMessage("hello")
+	assert.ErrorContains(t, err, "vault unavailable")
+
+	// Static keys provided by the source do not enable JWT authentication
+	k, err = New("", WithTokenSource(&rotatingTokenSource{token: "static_key"}), WithUserID(testUserID))
+	require.NoError(t, err)
+	assert.False(t, k.JWTAuth)
+	assert.Equal(t, UserIDSourceOption, k.UserIDSource)
+
+	// JWT authentication follows the source if it switches between a static key and a JWT
+	source = &rotatingTokenSource{token: "static_key"}
+	k, err = New("", WithKindroidID(testAIID), WithTokenSource(source), WithUserID(testUserID))
+	require.NoError(t, err)
+	_, err = k.ListChatMessages(context.Background(), testAIID, ListOptions{})
+	assert.ErrorIs(t, err, ErrJWTRequired)
+	source.rotate(first)
+	creds, err := k.checkFirestoreAccess(context.Background(), "testing")
+	require.NoError(t, err)
+	assert.True(t, creds.jwtAuth)
+	assert.Equal(t, testUserID, creds.userID)
+	source.rotate("static_key")
+	_, err = k.checkFirestoreAccess(context.Background(), "testing")
+	assert.ErrorIs(t, err, ErrJWTRequired)
+	source.rotate(testutil.JWT("other_user"))
+	_, err = k.checkFirestoreAccess(context.Background(), "testing")
+	assert.ErrorIs(t, err, ErrUserIDMismatch)
+
+	// The fields reflect the token at the time of setup and are not changed by requests
+	assert.False(t, k.JWTAuth)
+	assert.Equal(t, testUserID, k.UserID)
+	source.rotate(first)
+	require.NoError(t, k.SetupUserAndPermissions())
+	assert.True(t, k.JWTAuth)
+
+	// A token source replaces the refresh flow
+	_, err = New("", WithTokenSource(source), WithRefreshToken("refresh_0", "web_api_key"))
+	assert.Error(t, err)
+	_, err = New("", WithTokenSource(nil))
+	assert.Error(t, err)
+}
+
+func TestTokenSourceConcurrentRotation(t *testing.T) {
+	fake := testutil.NewFirestore(t, DefaultFirestoreProject)
+	for _, userID := range []string{testUserID, "other_user"} {
+		fake.PutMessage(t, userID, testAIID, testutil.Message{ID: "msg", Message: "hello " + userID, Sender: "ai", Timestamp: 1000})
+	}
+	tokens := []string{testutil.JWT(testUserID), "static_key", testutil.JWT("other_user")}
+	source := &rotatingTokenSource{token: tokens[0]}
+	k, err := New("", WithKindroidID(testAIID), WithFirestoreEmulator(fake.Addr), WithTokenSource(source))
+	require.NoError(t, err)
+	defer k.Close()
+
+	// Requests running while the source rotates use the user of a single token throughout
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for i := 0; ctx.Err() == nil; i++ {
+			source.rotate(tokens[i%len(tokens)])
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				creds, err := k.checkFirestoreAccess(ctx, "testing")
+				if err != nil {
+					assert.ErrorIs(t, err, ErrJWTRequired)
+				} else {
+					userID, _, errJWT := userIDFromJWT(creds.token)
+					assert.NoError(t, errJWT)
+					assert.Equal(t, userID, creds.userID)
+				}
+
+				msg, err := k.GetMessageById(ctx, testAIID, "msg")
+				if err != nil {
+					assert.ErrorIs(t, err, ErrJWTRequired)
+					continue
+				}
+				assert.Contains(t, []string{"hello " + testUserID, "hello other_user"}, msg.Message)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestFileTokenSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	first := testJWTExpiring(testUserID, time.Unix(1900000000, 0))
+	require.NoError(t, os.WriteFile(path, []byte(first+"\n"), 0o600))
+
+	fake := testutil.NewFirestore(t, DefaultFirestoreProject)
+	fake.PutMessage(t, testUserID, testAIID, testutil.Message{ID: "msg", Message: "hello", Sender: "ai", Timestamp: 1000})
+	k, err := New("", WithKindroidID(testAIID), WithFirestoreEmulator(fake.Addr), WithTokenSource(FileTokenSource(path)))
+	require.NoError(t, err)
+	defer k.Close()
+	assert.True(t, k.JWTAuth)
+	assert.True(t, time.Unix(1900000000, 0).Equal(k.TokenExpiresAt()))
+
+	_, err = k.GetMessageById(context.Background(), testAIID, "msg")
+	require.NoError(t, err)
+	assert.Contains(t, fake.Tokens(), first)
+
+	// Another process rotates the file
+	second := testJWTExpiring(testUserID, time.Unix(1900003600, 0))
+	require.NoError(t, os.WriteFile(path, []byte(second), 0o600))
+	modTime := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+	assert.Equal(t, second, k.currentToken())
+	_, err = k.GetMessageById(context.Background(), testAIID, "msg")
+	require.NoError(t, err)
+	assert.Contains(t, fake.Tokens(), second)
+
+	require.NoError(t, os.WriteFile(path, []byte("  \n"), 0o600))
+	require.NoError(t, os.Chtimes(path, modTime.Add(time.Minute), modTime.Add(time.Minute)))
+	_, err = k.token(context.Background())
+	assert.ErrorContains(t, err, "is empty")
+	_, err = FileTokenSource(filepath.Join(t.TempDir(), "missing")).Token()
+	assert.Error(t, err)
+}
@@ -47,12 +47,27 @@ type SendMessageOptions struct {
 	LinkDescription  *string  `json:"link_description,omitempty"`
 }
 
+// SendMessageResult represents the decoded response of the SendMessage API.
+type SendMessageResult struct {
+	// Text is the AI's reply with JSON quoting and escape sequences removed.
+	Text string
+	// MessageID is the ID of the reply message, if the backend returned one.
+	MessageID string
+	// Metadata contains any additional fields of a JSON object response.
+	Metadata map[string]any
+	// Raw is the unmodified response body.
+	Raw []byte
+}
+
 // AudioInferenceRequest represents the request body for the Audio Inference API.
 type AudioInferenceRequest struct {
 	AIID      string `json:"ai_id"`
 	MessageID string `json:"messageID"`
 }
 
+// DecryptionFailed replaces the Message or Audio of a ChatMessage which could not be decrypted.
+const DecryptionFailed = "[DECRYPTION FAILED]"
+
 // ChatMessage represents a single message in the chat history.
 type ChatMessage struct {
 	ID        string `firestore:"-"` // Firestore document ID, not a field in the document itself
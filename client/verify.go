@@ -0,0 +1,197 @@
+// Package client
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package client
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// DefaultJWKSURL serves the public keys used to sign Firebase ID tokens.
+	DefaultJWKSURL = "https://www.googleapis.com/service_accounts/v1/jwk/securetoken@system.gserviceaccount.com"
+	// DefaultJWTAudience is the audience of Kindroid's Firebase ID tokens.
+	DefaultJWTAudience = DefaultFirestoreProject
+	// DefaultJWTIssuer is the issuer of Kindroid's Firebase ID tokens.
+	DefaultJWTIssuer = "https://securetoken.google.com/" + DefaultFirestoreProject
+	// DefaultJWKSCacheTTL is how long fetched signing keys are cached.
+	DefaultJWKSCacheTTL = time.Hour
+)
+
+// jwksMinRefetchInterval limits how often the key set is fetched again because of an unknown key ID.
+const jwksMinRefetchInterval = time.Minute
+
+// JWTVerification configures the verification of JWT API keys.
+// Empty fields are set to their defaults.
+type JWTVerification struct {
+	// JWKSURL is the URL of the JSON Web Key Set used to verify token signatures.
+	JWKSURL string
+	// Audience is the expected aud claim.
+	Audience string
+	// Issuer is the expected iss claim.
+	Issuer string
+	// CacheTTL is how long the key set is cached.
+	CacheTTL time.Duration
+	// Leeway is the allowed clock skew when validating exp, iat and nbf.
+	Leeway time.Duration
+}
+
+// JWTVerificationError is returned if JWT verification is enabled and the API key fails verification.
+// It wraps the underlying error, e.g. jwt.ErrTokenExpired or jwt.ErrTokenSignatureInvalid.
+type JWTVerificationError struct {
+	Err error
+}
+
+func (e *JWTVerificationError) Error() string {
+	return "JWT verification failed: " + e.Err.Error()
+}
+
+func (e *JWTVerificationError) Unwrap() error {
+	return e.Err
+}
+
+// jwtVerifier verifies JWTs against a cached JSON Web Key Set.
+// It is safe for concurrent use.
+type jwtVerifier struct {
+	config JWTVerification
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// jsonWebKey is a single RSA key of a JSON Web Key Set.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// verify validates the signature, issuer, audience and expiry of the token and returns its claims.
+// The key set is fetched using the given HTTP client.
+func (v *jwtVerifier) verify(ctx context.Context, client *http.Client, tokenString string) (jwt.MapClaims, error) {
+	tokenString = strings.TrimPrefix(tokenString, "Bearer ")
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithAudience(v.config.Audience),
+		jwt.WithIssuer(v.config.Issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(v.config.Leeway),
+	)
+	_, err := parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("token has no key ID")
+		}
+		return v.key(ctx, client, kid)
+	})
+	if err != nil {
+		return nil, &JWTVerificationError{Err: err}
+	}
+	return claims, nil
+}
+
+// key returns the public key with the given ID, fetching the key set if it is not cached or outdated.
+func (v *jwtVerifier) key(ctx context.Context, client *http.Client, kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	expired := time.Since(v.fetchedAt) > v.config.CacheTTL
+	key, ok := v.keys[kid]
+	// Keys may have been rotated, so unknown key IDs cause a refetch, though not more than once a minute
+	if expired || (!ok && time.Since(v.fetchedAt) > jwksMinRefetchInterval) {
+		if err := v.fetchKeys(ctx, client); err == nil {
+			key, ok = v.keys[kid]
+		} else if !ok {
+			return nil, err
+		}
+		// Otherwise the cached key is used while the key set is unavailable
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// fetchKeys downloads and parses the key set. The caller must hold v.mu.
+func (v *jwtVerifier) fetchKeys(ctx context.Context, client *http.Client) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", v.config.JWKSURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch signing keys: %w", newAPIError(resp, "jwks"))
+	}
+
+	var keySet struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&keySet); err != nil {
+		return fmt.Errorf("failed to decode signing keys: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(keySet.Keys))
+	for _, jwk := range keySet.Keys {
+		if jwk.Kty != "RSA" || jwk.Kid == "" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return fmt.Errorf("invalid signing key %q: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	v.keys = keys
+	v.fetchedAt = time.Now()
+	return nil
+}
+
+// publicKey decodes the RSA public key from its base64url encoded modulus and exponent.
+func (jwk jsonWebKey) publicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %w", err)
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
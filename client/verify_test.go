@@ -0,0 +1,249 @@
+// Package client
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKeyServer serves a JSON Web Key Set like Google's securetoken endpoint.
+type fakeKeyServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	keys     map[string]*rsa.PrivateKey
+	requests int
+	hold     chan struct{} // if set, responses are delayed until it is closed
+}
+
+func newFakeKeyServer(t *testing.T) *fakeKeyServer {
+	f := &fakeKeyServer{keys: make(map[string]*rsa.PrivateKey)}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.requests++
+		hold := f.hold
+		f.mu.Unlock()
+		if hold != nil {
+			<-hold
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		var keys []map[string]string
+		for kid, key := range f.keys {
+			keys = append(keys, map[string]string{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": kid,
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// addKey generates a new signing key with the given key ID.
+func (f *fakeKeyServer) addKey(t *testing.T, kid string) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.keys[kid] = key
+	return key
+}
+
+// holdResponses delays responses until the returned function is called.
+func (f *fakeKeyServer) holdResponses(t *testing.T) func() {
+	hold := make(chan struct{})
+	f.mu.Lock()
+	f.hold = hold
+	f.mu.Unlock()
+	release := sync.OnceFunc(func() { close(hold) })
+	t.Cleanup(release)
+	return release
+}
+
+func (f *fakeKeyServer) requestCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+// signedTestJWT returns a Firebase-like ID token signed with the given key.
+// The given claims override the defaults; nil values remove a claim.
+func signedTestJWT(t *testing.T, key *rsa.PrivateKey, kid string, overrides jwt.MapClaims) string {
+	claims := jwt.MapClaims{
+		"iss":     DefaultJWTIssuer,
+		"aud":     DefaultJWTAudience,
+		"sub":     testUserID,
+		"user_id": testUserID,
+		"iat":     time.Now().Add(-time.Minute).Unix(),
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	}
+	for name, value := range overrides {
+		if value == nil {
+			delete(claims, name)
+			continue
+		}
+		claims[name] = value
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func TestJWTVerification(t *testing.T) {
+	keys := newFakeKeyServer(t)
+	key := keys.addKey(t, "key-1")
+	forgedKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	verification := WithJWTVerification(JWTVerification{JWKSURL: keys.URL})
+
+	// A valid token sets the UserID; clients created with the same option share the cached key set
+	for range 2 {
+		k, err := New(signedTestJWT(t, key, "key-1", nil), verification)
+		require.NoError(t, err)
+		assert.Equal(t, testUserID, k.UserID)
+		assert.True(t, k.JWTAuth)
+	}
+	assert.Equal(t, 1, keys.requestCount())
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"forged signature", signedTestJWT(t, forgedKey, "key-1", nil), jwt.ErrTokenSignatureInvalid},
+		{"wrong audience", signedTestJWT(t, key, "key-1", jwt.MapClaims{"aud": "other-project"}), jwt.ErrTokenInvalidAudience},
+		{"wrong issuer", signedTestJWT(t, key, "key-1", jwt.MapClaims{"iss": "https://evil.example.com"}), jwt.ErrTokenInvalidIssuer},
+		{"expired", signedTestJWT(t, key, "key-1", jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}), jwt.ErrTokenExpired},
+		{"missing expiry", signedTestJWT(t, key, "key-1", jwt.MapClaims{"exp": nil}), jwt.ErrTokenRequiredClaimMissing},
+		{"unsigned", testutil.JWT(testUserID), jwt.ErrTokenSignatureInvalid},
+		{"unknown key", signedTestJWT(t, key, "key-2", nil), jwt.ErrTokenUnverifiable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := New(tt.token, verification)
+			assert.Nil(t, k)
+			var verificationErr *JWTVerificationError
+			require.True(t, errors.As(err, &verificationErr), "unexpected error: %v", err)
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+
+	// Static API keys are not affected by JWT verification
+	k, err := New("static_api_key", verification, WithUserID(testUserID))
+	require.NoError(t, err)
+	assert.False(t, k.JWTAuth)
+}
+
+func TestJWTVerificationSlowKeySet(t *testing.T) {
+	keys := newFakeKeyServer(t)
+	first := signedTestJWT(t, keys.addKey(t, "key-1"), "key-1", nil)
+	source := &rotatingTokenSource{token: first}
+	k, err := New("", WithTokenSource(source), WithJWTVerification(JWTVerification{JWKSURL: keys.URL}))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// Verifying a token signed with a new key fetches the key set, which hangs
+	rotated := signedTestJWT(t, keys.addKey(t, "key-2"), "key-2", nil)
+	k.verifier.fetchedAt = time.Now().Add(-2 * jwksMinRefetchInterval)
+	release := keys.holdResponses(t)
+	derived := make(chan error, 1)
+	go func() {
+		_, err := k.deriveFromToken(ctx, rotated)
+		derived <- err
+	}()
+	require.Eventually(t, func() bool { return keys.requestCount() == 2 }, 5*time.Second, time.Millisecond)
+
+	// Meanwhile, requests with the previously derived token are not held up
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		creds, err := k.token(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, first, creds.token)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("token was blocked by the verification of another token")
+	}
+
+	release()
+	require.NoError(t, <-derived)
+	creds, err := k.token(ctx)
+	require.NoError(t, err)
+	assert.True(t, creds.jwtAuth)
+}
+
+func TestJWTVerificationKeyRotation(t *testing.T) {
+	keys := newFakeKeyServer(t)
+	keys.addKey(t, "key-1")
+	k, err := New("", WithJWTVerification(JWTVerification{JWKSURL: keys.URL}))
+	require.NoError(t, err)
+
+	// A key added after the key set was cached is found by fetching the key set again
+	_, err = k.verifier.key(context.Background(), k.Client, "key-1")
+	require.NoError(t, err)
+	rotated := keys.addKey(t, "key-2")
+	k.verifier.fetchedAt = time.Now().Add(-2 * jwksMinRefetchInterval)
+	claims, err := k.verifier.verify(context.Background(), k.Client, signedTestJWT(t, rotated, "key-2", nil))
+	require.NoError(t, err)
+	assert.Equal(t, testUserID, claims["user_id"])
+	assert.Equal(t, 2, keys.requestCount())
+
+	// Unknown key IDs do not cause a refetch more than once a minute
+	_, err = k.verifier.key(context.Background(), k.Client, "key-3")
+	assert.Error(t, err)
+	assert.Equal(t, 2, keys.requestCount())
+
+	// Cached keys are still used if the key set is temporarily unavailable
+	keys.Close()
+	k.verifier.fetchedAt = time.Now().Add(-2 * DefaultJWKSCacheTTL)
+	_, err = k.verifier.key(context.Background(), k.Client, "key-1")
+	assert.NoError(t, err)
+	_, err = k.verifier.key(context.Background(), k.Client, "key-3")
+	assert.Error(t, err)
+
+	_, err = New("", WithJWTVerification(JWTVerification{JWKSURL: "ftp://example.com"}))
+	assert.Error(t, err)
+}
@@ -0,0 +1,240 @@
+// Package client
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package client
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Reconnect delays used by WatchChatMessages after the snapshot stream failed.
+const (
+	watchInitialBackoff = time.Second
+	watchMaxBackoff     = 30 * time.Second
+)
+
+// ChatEventType describes the kind of change reported by a ChatEvent.
+type ChatEventType int
+
+const (
+	// ChatMessageAdded is emitted for a new message.
+	ChatMessageAdded ChatEventType = iota
+	// ChatMessageModified is emitted when an existing message changed, e.g. when audio was generated for it.
+	ChatMessageModified
+	// ChatMessageRemoved is emitted when a message was deleted.
+	ChatMessageRemoved
+	// ChatWatchError is emitted when the underlying snapshot stream failed. The watch reconnects automatically.
+	ChatWatchError
+)
+
+// String returns a human-readable name of the event type.
+func (t ChatEventType) String() string {
+	switch t {
+	case ChatMessageAdded:
+		return "added"
+	case ChatMessageModified:
+		return "modified"
+	case ChatMessageRemoved:
+		return "removed"
+	case ChatWatchError:
+		return "error"
+	}
+	return "unknown"
+}
+
+// ChatEvent is a single change to the chat history observed by WatchChatMessages.
+type ChatEvent struct {
+	Type ChatEventType
+	// Message is the decrypted message. For removed messages, it is the last known version.
+	// It is nil for ChatWatchError events.
+	Message *ChatMessage
+	// Err is set for ChatWatchError events.
+	Err error
+}
+
+// watchChange is a document change as seen by the watch state, independent of Firestore types.
+type watchChange struct {
+	kind       firestore.DocumentChangeKind
+	id         string
+	updateTime time.Time
+	message    *ChatMessage
+}
+
+// watchedMessage is the last known version of a message.
+type watchedMessage struct {
+	updateTime time.Time
+	message    *ChatMessage
+}
+
+// watchState tracks the known messages of a watch, so reconnecting does not replay messages
+// which were already reported.
+type watchState struct {
+	known map[string]watchedMessage
+}
+
+func newWatchState() *watchState {
+	return &watchState{known: make(map[string]watchedMessage)}
+}
+
+// apply updates the state with the changes of a snapshot and returns the events to emit.
+// After a reconnect, the first snapshot lists all matching documents as added; initial must be set
+// for this snapshot, so documents which disappeared in the meantime are reported as removed.
+func (s *watchState) apply(changes []watchChange, initial bool) []ChatEvent {
+	var events []ChatEvent
+	seen := make(map[string]bool, len(changes))
+
+	for _, change := range changes {
+		seen[change.id] = true
+		previous, isKnown := s.known[change.id]
+
+		switch change.kind {
+		case firestore.DocumentRemoved:
+			if !isKnown {
+				continue
+			}
+			delete(s.known, change.id)
+			message := change.message
+			if message == nil {
+				message = previous.message
+			}
+			events = append(events, ChatEvent{Type: ChatMessageRemoved, Message: message})
+
+		default:
+			if change.message == nil {
+				continue
+			}
+			s.known[change.id] = watchedMessage{updateTime: change.updateTime, message: change.message}
+			switch {
+			case !isKnown:
+				events = append(events, ChatEvent{Type: ChatMessageAdded, Message: change.message})
+			case !previous.updateTime.Equal(change.updateTime):
+				events = append(events, ChatEvent{Type: ChatMessageModified, Message: change.message})
+			}
+		}
+	}
+
+	if initial {
+		for id, previous := range s.known {
+			if !seen[id] {
+				delete(s.known, id)
+				events = append(events, ChatEvent{Type: ChatMessageRemoved, Message: previous.message})
+			}
+		}
+	}
+	return events
+}
+
+// WatchChatMessages subscribes to changes of the chat history of the given AI, starting now.
+// New messages, modifications and deletions of messages sent after the call are delivered as
+// events with decrypted messages, e.g. replies produced from the Kindroid app on another device.
+//
+// If the snapshot stream fails, a ChatWatchError event is emitted and the watch reconnects with
+// exponential backoff, without repeating events which were already delivered.
+// The returned channel is closed once ctx is done.
+func (k *KindroidAI) WatchChatMessages(ctx context.Context, aiID string) (<-chan ChatEvent, error) {
+	// The watch keeps the user of the credentials it was started with
+	creds, err := k.checkFirestoreAccess(ctx, "watching messages")
+	if err != nil {
+		return nil, err
+	}
+	// Make sure the Firestore client can be created before starting the watch.
+	_, release, err := k.firestoreClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	release()
+
+	start := time.Now().UnixMilli()
+	events := make(chan ChatEvent)
+
+	go func() {
+		defer close(events)
+
+		state := newWatchState()
+		backoff := watchInitialBackoff
+		for {
+			err := k.watchSnapshots(ctx, creds.userID, aiID, start, state, events, func() { backoff = watchInitialBackoff })
+			if ctx.Err() != nil {
+				return
+			}
+
+			k.logger().Warn("chat message watch interrupted, reconnecting", "aiID", aiID, "delay", backoff, "error", err)
+			select {
+			case events <- ChatEvent{Type: ChatWatchError, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+			backoff = min(backoff*2, watchMaxBackoff)
+		}
+	}()
+
+	return events, nil
+}
+
+// watchSnapshots runs a single snapshot listener until it fails or ctx is done.
+// onSnapshot is called for every received snapshot.
+func (k *KindroidAI) watchSnapshots(ctx context.Context, userID, aiID string, start int64, state *watchState, events chan<- ChatEvent, onSnapshot func()) error {
+	client, release, err := k.firestoreClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	snapshots := chatMessagesCollection(client, userID, aiID).
+		Where("timestamp", ">=", start).
+		Snapshots(ctx)
+	defer snapshots.Stop()
+
+	initial := true
+	for {
+		snapshot, err := snapshots.Next()
+		if err != nil {
+			return err
+		}
+		onSnapshot()
+
+		changes := make([]watchChange, 0, len(snapshot.Changes))
+		for _, change := range snapshot.Changes {
+			wc := watchChange{kind: change.Kind, id: change.Doc.Ref.ID, updateTime: change.Doc.UpdateTime}
+			if msg, errDecode := k.messageFromFirebaseDocument(change.Doc, userID); errDecode == nil {
+				wc.message = msg
+			} else {
+				k.logger().Warn("failed to parse chat message document", "doc", change.Doc.Ref.ID, "error", errDecode)
+			}
+			changes = append(changes, wc)
+		}
+
+		for _, event := range state.apply(changes, initial) {
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		initial = false
+	}
+}
@@ -0,0 +1,86 @@
+// Package client
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchStateReconnect(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	t1 := t0.Add(time.Second)
+	msg := func(id, text string) *ChatMessage { return &ChatMessage{ID: id, Message: text} }
+	types := func(events []ChatEvent) []ChatEventType {
+		var result []ChatEventType
+		for _, event := range events {
+			result = append(result, event.Type)
+		}
+		return result
+	}
+
+	state := newWatchState()
+
+	// First connection
+	events := state.apply([]watchChange{
+		{kind: firestore.DocumentAdded, id: "a", updateTime: t0, message: msg("a", "hello")},
+		{kind: firestore.DocumentAdded, id: "b", updateTime: t0, message: msg("b", "hi")},
+	}, true)
+	assert.Equal(t, []ChatEventType{ChatMessageAdded, ChatMessageAdded}, types(events))
+
+	events = state.apply([]watchChange{
+		{kind: firestore.DocumentModified, id: "b", updateTime: t1, message: msg("b", "hi with audio")},
+	}, false)
+	assert.Equal(t, []ChatEventType{ChatMessageModified}, types(events))
+	assert.Equal(t, "hi with audio", events[0].Message.Message)
+
+	// After reconnecting, all documents are reported as added again.
+	// Only real changes may be emitted: "a" was deleted, "b" unchanged and "c" is new.
+	events = state.apply([]watchChange{
+		{kind: firestore.DocumentAdded, id: "b", updateTime: t1, message: msg("b", "hi with audio")},
+		{kind: firestore.DocumentAdded, id: "c", updateTime: t1, message: msg("c", "new")},
+	}, true)
+	assert.ElementsMatch(t, []ChatEventType{ChatMessageAdded, ChatMessageRemoved}, types(events))
+	for _, event := range events {
+		switch event.Type {
+		case ChatMessageAdded:
+			assert.Equal(t, "c", event.Message.ID)
+		case ChatMessageRemoved:
+			assert.Equal(t, "a", event.Message.ID, "removed events carry the last known message")
+		}
+	}
+
+	// Removal without message data falls back to the known message; unknown removals are ignored
+	events = state.apply([]watchChange{
+		{kind: firestore.DocumentRemoved, id: "c"},
+		{kind: firestore.DocumentRemoved, id: "unknown"},
+	}, false)
+	assert.Equal(t, []ChatEventType{ChatMessageRemoved}, types(events))
+	assert.Equal(t, "new", events[0].Message.Message)
+}
+
+func TestWatchChatMessagesRequiresJWT(t *testing.T) {
+	k := NewKindroidAI("test_api_key", "test_ai_id")
+	events, err := k.WatchChatMessages(context.Background(), "test_ai_id")
+	assert.ErrorIs(t, err, ErrJWTRequired)
+	assert.Nil(t, events)
+}
@@ -0,0 +1,179 @@
+// Package main
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/client"
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/server"
+)
+
+// Environment variables used as flag defaults; the client variables match those of kindroidctl.
+const (
+	envAPIKey         = "KINDROID_API_KEY"
+	envAIID           = "KINDROID_AI_ID"
+	envBaseURL        = "KINDROID_BASE_URL"
+	envRefreshToken   = "KINDROID_REFRESH_TOKEN"
+	envFirebaseAPIKey = "KINDROID_FIREBASE_API_KEY"
+	envTokenFile      = "KINDROID_TOKEN_FILE"
+	envTokenURL       = "KINDROID_TOKEN_REFRESH_URL"
+	envProxyKey       = "KINDROID_PROXY_KEY"
+)
+
+// shutdownTimeout is the time given to running requests when the proxy is stopped.
+const shutdownTimeout = 10 * time.Second
+
+// settings holds the configuration of the proxy.
+type settings struct {
+	listen         string
+	aiIDs          []string
+	apiKey         string
+	refreshToken   string
+	firebaseAPIKey string
+	tokenFile      string
+	tokenURL       string
+	baseURL        string
+	proxyKey       string
+	breakCommand   string
+	verbose        bool
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "kindroid-openai-proxy:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("kindroid-openai-proxy", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: kindroid-openai-proxy [flags]")
+		fmt.Fprintln(fs.Output(), "\nServes the OpenAI chat completions and speech APIs, exposing each Kindroid AI as a model.\n\nFlags:")
+		fs.PrintDefaults()
+	}
+	s := settings{tokenURL: os.Getenv(envTokenURL)}
+	var aiIDs string
+	fs.StringVar(&s.listen, "listen", "localhost:8080", "address to listen on")
+	fs.StringVar(&aiIDs, "ai", os.Getenv(envAIID), "comma separated AI IDs exposed as models ($"+envAIID+")")
+	fs.StringVar(&s.apiKey, "api-key", os.Getenv(envAPIKey), "Kindroid API key or JWT ($"+envAPIKey+")")
+	fs.StringVar(&s.refreshToken, "refresh-token", os.Getenv(envRefreshToken), "Firebase refresh token ($"+envRefreshToken+")")
+	fs.StringVar(&s.firebaseAPIKey, "firebase-api-key", os.Getenv(envFirebaseAPIKey), "Firebase web API key for the refresh token ($"+envFirebaseAPIKey+")")
+	fs.StringVar(&s.tokenFile, "token-file", os.Getenv(envTokenFile), "file containing the current JWT ($"+envTokenFile+")")
+	fs.StringVar(&s.baseURL, "base-url", os.Getenv(envBaseURL), "Kindroid API base URL ($"+envBaseURL+")")
+	fs.StringVar(&s.proxyKey, "proxy-key", os.Getenv(envProxyKey), "API key clients must send as Bearer token ($"+envProxyKey+")")
+	fs.StringVar(&s.breakCommand, "break-command", server.DefaultBreakCommand, "command which starts a new chat with the given greeting")
+	fs.BoolVar(&s.verbose, "v", false, "log debug output")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	for _, id := range strings.Split(aiIDs, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			s.aiIDs = append(s.aiIDs, id)
+		}
+	}
+
+	level := slog.LevelInfo
+	if s.verbose {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	k, err := newClient(ctx, s, logger)
+	if err != nil {
+		return err
+	}
+	defer k.Close()
+
+	handler, err := server.New(k, server.Config{AIIDs: s.aiIDs, BreakCommand: s.breakCommand, APIKey: s.proxyKey, Logger: logger})
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Addr: s.listen, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	errs := make(chan error, 1)
+	go func() {
+		logger.Info("listening", "address", s.listen, "models", s.aiIDs, "jwt", k.JWTAuth)
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err = <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	logger.Info("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err = srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err = <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// newClient creates the Kindroid client for the proxy.
+// With a refresh token, the ID token is obtained before serving, so the chat history and audio are available.
+func newClient(ctx context.Context, s settings, logger *slog.Logger) (*client.KindroidAI, error) {
+	if len(s.aiIDs) == 0 {
+		return nil, fmt.Errorf("no AI IDs configured; use --ai or $%s", envAIID)
+	}
+	opts := []client.Option{client.WithKindroidID(s.aiIDs[0]), client.WithUserAgent("kindroid-openai-proxy"), client.WithLogger(logger)}
+	if s.baseURL != "" {
+		opts = append(opts, client.WithBaseURL(s.baseURL))
+	}
+	refresh := false
+	switch {
+	case s.tokenFile != "":
+		opts = append(opts, client.WithTokenSource(client.FileTokenSource(s.tokenFile)))
+	case s.refreshToken != "":
+		opts = append(opts, client.WithRefreshToken(s.refreshToken, s.firebaseAPIKey))
+		if s.tokenURL != "" {
+			opts = append(opts, client.WithTokenRefreshURL(s.tokenURL))
+		}
+		refresh = true
+	case s.apiKey == "":
+		return nil, fmt.Errorf("no credentials configured; use --api-key or $%s", envAPIKey)
+	}
+	k, err := client.New(s.apiKey, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if refresh {
+		if err = k.SetupUserAndPermissionsContext(ctx); err != nil {
+			k.Close()
+			return nil, fmt.Errorf("failed to obtain an ID token: %w", err)
+		}
+	}
+	return k, nil
+}
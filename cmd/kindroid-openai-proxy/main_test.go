@@ -0,0 +1,93 @@
+// Package main
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/client"
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/internal/testutil"
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testAIID      = "test_ai_id"
+	testUserID    = "test_user_id"
+	testMessageID = "test_message_id"
+)
+
+func TestRefreshTokenSpeech(t *testing.T) {
+	speech, err := os.ReadFile("../../audio/testdata/speech.mp3")
+	require.NoError(t, err)
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Write(speech)
+	}))
+	defer storage.Close()
+	firestore := testutil.NewFirestore(t, client.DefaultFirestoreProject)
+	firestore.PutMessage(t, testUserID, testAIID, testutil.Message{
+		ID: testMessageID, Message: "Hello there", Sender: client.SenderAI, Timestamp: 1700000000000, Audio: storage.URL + "/speech.mp3",
+	})
+	t.Setenv("FIRESTORE_EMULATOR_HOST", firestore.Addr)
+	idToken := testutil.JWT(testUserID)
+	secureToken, refreshes := testutil.NewSecureTokenServer(t, "refresh_token", idToken)
+
+	// Only a refresh token is configured; the ID token is obtained before serving
+	s := settings{aiIDs: []string{testAIID}, refreshToken: "refresh_token", tokenURL: secureToken.URL, breakCommand: server.DefaultBreakCommand}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	k, err := newClient(context.Background(), s, logger)
+	require.NoError(t, err)
+	defer k.Close()
+	assert.True(t, k.JWTAuth)
+	assert.Equal(t, testUserID, k.UserID)
+	assert.Equal(t, int32(1), refreshes.Load())
+
+	handler, err := server.New(k, server.Config{AIIDs: s.aiIDs, BreakCommand: s.breakCommand, Logger: logger})
+	require.NoError(t, err)
+	proxy := httptest.NewServer(handler)
+	defer proxy.Close()
+
+	resp, err := http.Post(proxy.URL+"/v1/audio/speech", "application/json",
+		strings.NewReader(`{"model":"`+testAIID+`","message_id":"`+testMessageID+`"}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	assert.Equal(t, "audio/mpeg", resp.Header.Get("Content-Type"))
+	assert.True(t, bytes.Equal(speech, body))
+	assert.Equal(t, []string{idToken}, firestore.Tokens())
+}
+
+func TestRefreshTokenLoginFailure(t *testing.T) {
+	secureToken, _ := testutil.NewSecureTokenServer(t, "refresh_token", testutil.JWT(testUserID))
+
+	s := settings{aiIDs: []string{testAIID}, refreshToken: "revoked", tokenURL: secureToken.URL}
+	_, err := newClient(context.Background(), s, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	assert.ErrorContains(t, err, "failed to obtain an ID token")
+}
@@ -0,0 +1,536 @@
+// Package main
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/audio"
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/client"
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/export"
+	"google.golang.org/api/iterator"
+)
+
+var sendCommand = command{
+	usage:   "[flags] <message>",
+	summary: "Send a message and print the reply. The message is read from stdin if omitted or \"-\".",
+	flags: func(fs *flag.FlagSet) any {
+		return fs.Bool("stream", false, "print the reply while it is generated (text output only)")
+	},
+	run: func(ctx context.Context, e *env, args []string, flags any) error {
+		message, err := messageArgument(e, args)
+		if err != nil {
+			return err
+		}
+		k, err := e.cfg.newClient(ctx, true)
+		if err != nil {
+			return err
+		}
+		defer k.Close()
+
+		options := client.SendMessageOptions{AIID: k.KindroidID, Message: message}
+		if *flags.(*bool) && e.out.format == outputText {
+			_, err = streamReply(ctx, k, options, e.stdout)
+			return err
+		}
+		result, err := k.SendMessageWithResult(ctx, options)
+		if err != nil {
+			return err
+		}
+		return e.out.print(view{
+			JSON:   sendOutput{Reply: result.Text, MessageID: result.MessageID},
+			Header: []string{"MESSAGE ID", "REPLY"},
+			Rows:   [][]string{{result.MessageID, result.Text}},
+			Text:   result.Text,
+		})
+	},
+}
+
+type sendOutput struct {
+	Reply     string `json:"reply"`
+	MessageID string `json:"message_id,omitempty"`
+}
+
+// streamReply sends a message and writes the reply to w while it is generated.
+func streamReply(ctx context.Context, k *client.KindroidAI, options client.SendMessageOptions, w io.Writer) (string, error) {
+	stream, err := k.SendMessageStream(ctx, options)
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+	for stream.Next() {
+		fmt.Fprint(w, stream.Chunk())
+	}
+	fmt.Fprintln(w)
+	return stream.Message(), stream.Err()
+}
+
+var chatCommand = command{
+	usage:   "[flags]",
+	summary: "Chat interactively. Type /break <greeting> to start a new chat and /quit to exit.",
+	run: func(ctx context.Context, e *env, args []string, _ any) error {
+		if len(args) > 0 {
+			return usageError{errors.New("chat does not take arguments")}
+		}
+		k, err := e.cfg.newClient(ctx, true)
+		if err != nil {
+			return err
+		}
+		defer k.Close()
+
+		scanner := bufio.NewScanner(e.stdin)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for {
+			fmt.Fprint(e.stderr, "> ")
+			if !scanner.Scan() {
+				fmt.Fprintln(e.stderr)
+				return scanner.Err()
+			}
+			line := strings.TrimSpace(scanner.Text())
+			switch {
+			case line == "":
+				continue
+			case line == "/quit" || line == "/exit":
+				return nil
+			case line == "/break" || strings.HasPrefix(line, "/break "):
+				greeting := strings.TrimSpace(strings.TrimPrefix(line, "/break"))
+				if greeting == "" {
+					fmt.Fprintln(e.stderr, "Usage: /break <greeting>")
+					continue
+				}
+				if err = k.ChatBreakContext(ctx, greeting); err != nil {
+					return err
+				}
+				fmt.Fprintf(e.stdout, "--- chat break ---\n%s\n", greeting)
+				continue
+			}
+			if _, err = streamReply(ctx, k, client.SendMessageOptions{AIID: k.KindroidID, Message: line}, e.stdout); err != nil {
+				return err
+			}
+		}
+	},
+}
+
+var breakCommand = command{
+	usage:   "[flags] <greeting>",
+	summary: "End the current chat and start a new one with the given greeting of the AI.",
+	run: func(ctx context.Context, e *env, args []string, _ any) error {
+		greeting, err := messageArgument(e, args)
+		if err != nil {
+			return err
+		}
+		k, err := e.cfg.newClient(ctx, true)
+		if err != nil {
+			return err
+		}
+		defer k.Close()
+
+		if err = k.ChatBreakContext(ctx, greeting); err != nil {
+			return err
+		}
+		return e.out.print(view{
+			JSON: map[string]any{"ai_id": k.KindroidID, "greeting": greeting},
+			Text: "Chat break sent.",
+		})
+	},
+}
+
+type historyFlags struct {
+	limit  int
+	before timeFlag
+	after  timeFlag
+}
+
+// timeFlag parses RFC 3339 timestamps.
+type timeFlag struct{ time.Time }
+
+func (t *timeFlag) String() string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func (t *timeFlag) Set(s string) error {
+	parsed, err := time.Parse(time.RFC3339, s)
+	t.Time = parsed
+	return err
+}
+
+var historyCommand = command{
+	usage:   "[flags]",
+	summary: "Print the chat history, oldest message first. Requires a JWT.",
+	flags: func(fs *flag.FlagSet) any {
+		f := &historyFlags{}
+		fs.IntVar(&f.limit, "limit", 20, "maximum number of messages, starting with the newest")
+		fs.Var(&f.before, "before", "only messages sent before this RFC 3339 time")
+		fs.Var(&f.after, "after", "only messages sent after this RFC 3339 time")
+		return f
+	},
+	run: func(ctx context.Context, e *env, args []string, flags any) error {
+		f := flags.(*historyFlags)
+		if len(args) > 0 {
+			return usageError{errors.New("history does not take arguments")}
+		}
+		if f.limit <= 0 {
+			return usageError{errors.New("--limit must be positive")}
+		}
+		k, err := e.cfg.newClient(ctx, true)
+		if err != nil {
+			return err
+		}
+		defer k.Close()
+
+		var messages []*client.ChatMessage
+		it := k.AllChatMessages(ctx, k.KindroidID, client.ListOptions{
+			PageSize: min(f.limit, client.DefaultPageSize),
+			Before:   f.before.Time,
+			After:    f.after.Time,
+		})
+		for len(messages) < f.limit {
+			message, err := it.Next()
+			if errors.Is(err, iterator.Done) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			messages = append(messages, message)
+		}
+		slices.Reverse(messages)
+
+		output := make([]historyMessage, len(messages))
+		rows := make([][]string, len(messages))
+		var text strings.Builder
+		for i, message := range messages {
+			timestamp := message.GetTime().Format(time.RFC3339)
+			output[i] = historyMessage{ID: message.ID, Sender: message.Sender, Message: message.Message, Time: timestamp, Audio: message.Audio}
+			rows[i] = []string{timestamp, message.Sender, message.ID, message.Message}
+			fmt.Fprintf(&text, "[%s] %s: %s\n", message.GetTime().Format("2006-01-02 15:04:05"), message.Sender, message.Message)
+		}
+		return e.out.print(view{JSON: output, Header: []string{"TIME", "SENDER", "ID", "MESSAGE"}, Rows: rows, Text: text.String()})
+	},
+}
+
+type historyMessage struct {
+	ID      string `json:"id"`
+	Sender  string `json:"sender"`
+	Message string `json:"message"`
+	Time    string `json:"time"`
+	Audio   string `json:"audio,omitempty"`
+}
+
+type exportFlags struct {
+	format     string
+	output     string
+	title      string
+	userLabel  string
+	aiLabel    string
+	timeFormat string
+	timezone   string
+	audio      string
+	audioDir   string
+	after      timeFlag
+	before     timeFlag
+	newest     bool
+}
+
+// exportExtensions maps file extensions to the format used if --format is not set.
+var exportExtensions = map[string]export.Format{
+	".jsonl": export.FormatJSONL,
+	".md":    export.FormatMarkdown,
+	".html":  export.FormatHTML,
+	".htm":   export.FormatHTML,
+	".csv":   export.FormatCSV,
+}
+
+var exportCommand = command{
+	usage:   "[flags]",
+	summary: "Export the complete chat history as JSONL, Markdown, HTML or CSV, one page at a time. Requires a JWT.",
+	flags: func(fs *flag.FlagSet) any {
+		f := &exportFlags{}
+		fs.StringVar(&f.format, "format", "", "jsonl, markdown, html or csv (default from the -o extension, else jsonl)")
+		fs.StringVar(&f.output, "o", "-", "output file, or \"-\" for stdout")
+		fs.StringVar(&f.title, "title", "", "title of Markdown and HTML transcripts")
+		fs.StringVar(&f.userLabel, "user-label", "", "name shown for the user's messages")
+		fs.StringVar(&f.aiLabel, "ai-label", "", "name shown for the AI's messages")
+		fs.StringVar(&f.timeFormat, "time-format", "", "Go time layout of timestamps")
+		fs.StringVar(&f.timezone, "tz", "", "IANA time zone of timestamps, e.g. Europe/Berlin (default local)")
+		fs.StringVar(&f.audio, "audio", "none", "none, link (download into --audio-dir) or embed")
+		fs.StringVar(&f.audioDir, "audio-dir", "audio", "directory for linked audio files")
+		fs.Var(&f.after, "after", "only messages sent after this RFC 3339 time")
+		fs.Var(&f.before, "before", "only messages sent before this RFC 3339 time")
+		fs.BoolVar(&f.newest, "newest-first", false, "write the newest message first, reading the history once instead of twice")
+		return f
+	},
+	run: func(ctx context.Context, e *env, args []string, flags any) error {
+		f := flags.(*exportFlags)
+		if len(args) > 0 {
+			return usageError{errors.New("export does not take arguments")}
+		}
+		format := export.Format(f.format)
+		if format == "" {
+			format = export.FormatJSONL
+			if byExtension, ok := exportExtensions[strings.ToLower(filepath.Ext(f.output))]; ok {
+				format = byExtension
+			}
+		}
+		if !slices.Contains(export.Formats, format) {
+			return usageError{fmt.Errorf("unknown export format %q", format)}
+		}
+		opts := export.Options{
+			Title:       f.title,
+			UserLabel:   f.userLabel,
+			AILabel:     f.aiLabel,
+			TimeFormat:  f.timeFormat,
+			After:       f.after.Time,
+			Before:      f.before.Time,
+			AudioDir:    f.audioDir,
+			NewestFirst: f.newest,
+		}
+		if f.timezone != "" {
+			location, err := time.LoadLocation(f.timezone)
+			if err != nil {
+				return usageError{err}
+			}
+			opts.Location = location
+		}
+		switch f.audio {
+		case "none":
+		case "link":
+			opts.Audio = export.AudioLink
+		case "embed":
+			opts.Audio = export.AudioEmbed
+		default:
+			return usageError{fmt.Errorf("unknown audio mode %q", f.audio)}
+		}
+
+		k, err := e.cfg.newClient(ctx, true)
+		if err != nil {
+			return err
+		}
+		defer k.Close()
+		opts.AudioSource = k
+
+		var w io.Writer = e.stdout
+		var file *os.File
+		if f.output != "-" {
+			if file, err = os.Create(f.output); err != nil {
+				return err
+			}
+			defer file.Close()
+			w = file
+		}
+		count, err := export.Export(ctx, k, w, format, opts)
+		if err != nil {
+			return err
+		}
+		if file == nil {
+			return nil
+		}
+		if err = file.Close(); err != nil {
+			return err
+		}
+		return e.out.print(view{
+			JSON: map[string]any{"file": f.output, "format": format, "messages": count},
+			Text: fmt.Sprintf("Exported %d messages to %s", count, f.output),
+		})
+	},
+}
+
+type audioFlags struct {
+	output string
+	wav    bool
+}
+
+var audioCommand = command{
+	usage:   "[flags] <message-id>",
+	summary: "Download the voice audio of an AI message, generating it if necessary. Requires a JWT.",
+	flags: func(fs *flag.FlagSet) any {
+		f := &audioFlags{}
+		fs.StringVar(&f.output, "o", "", "output file, or \"-\" for stdout (default <message-id>.mp3 or .wav)")
+		fs.BoolVar(&f.wav, "wav", false, "convert the audio to WAV")
+		return f
+	},
+	run: func(ctx context.Context, e *env, args []string, flags any) error {
+		f := flags.(*audioFlags)
+		if len(args) != 1 {
+			return usageError{errors.New("audio requires exactly one message ID")}
+		}
+		messageID := args[0]
+		path := f.output
+		if path == "" {
+			path = messageID + ".mp3"
+			if f.wav {
+				path = messageID + ".wav"
+			}
+		}
+
+		k, err := e.cfg.newClient(ctx, true)
+		if err != nil {
+			return err
+		}
+		defer k.Close()
+		stream, info, err := k.AudioStream(ctx, messageID)
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+
+		var w io.Writer = e.stdout
+		var file *os.File
+		if path != "-" {
+			if file, err = os.Create(path); err != nil {
+				return err
+			}
+			defer file.Close()
+			w = file
+		}
+		if f.wav {
+			decoder, err := audio.NewDecoder(stream)
+			if err != nil {
+				return err
+			}
+			if err = audio.EncodeWAV(w, decoder.Format(), decoder); err != nil {
+				return err
+			}
+			info.ContentType = "audio/wav"
+		} else if _, err = io.Copy(w, stream); err != nil {
+			return err
+		}
+		if file == nil {
+			return nil
+		}
+		if err = file.Close(); err != nil {
+			return err
+		}
+
+		stat, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		size := stat.Size()
+		return e.out.print(view{
+			JSON:   audioOutput{MessageID: messageID, File: path, ContentType: info.ContentType, Size: size},
+			Header: []string{"MESSAGE ID", "FILE", "TYPE", "SIZE"},
+			Rows:   [][]string{{messageID, path, info.ContentType, strconv.FormatInt(size, 10)}},
+			Text:   fmt.Sprintf("Saved %d bytes of %s to %s", size, info.ContentType, path),
+		})
+	},
+}
+
+type audioOutput struct {
+	MessageID   string `json:"message_id"`
+	File        string `json:"file"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+var subscriptionCommand = command{
+	usage:   "[flags]",
+	summary: "Print the subscription status of the user.",
+	run: func(ctx context.Context, e *env, args []string, _ any) error {
+		k, err := e.cfg.newClient(ctx, false)
+		if err != nil {
+			return err
+		}
+		defer k.Close()
+		info, err := k.CheckUserSubscriptionContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		rows := [][]string{
+			{"user_id", info.UID},
+			{"status", info.Status},
+			{"base", subscriptionStatus(info.IsSubscribedBase, &info.SubscriptionPlatformBase)},
+			{"addon1", subscriptionStatus(info.IsSubscribedAddon1, info.SubscriptionPlatformAddon1)},
+			{"addon2", subscriptionStatus(info.IsSubscribedAddon2, info.SubscriptionPlatformAddon2)},
+		}
+		return e.out.print(view{JSON: info, Header: []string{"KEY", "VALUE"}, Rows: rows, Text: keyValueText(rows)})
+	},
+}
+
+func subscriptionStatus(subscribed bool, platform *string) string {
+	if !subscribed {
+		return "not subscribed"
+	}
+	if platform != nil && *platform != "" {
+		return "subscribed (" + *platform + ")"
+	}
+	return "subscribed"
+}
+
+var whoamiCommand = command{
+	usage:   "[flags]",
+	summary: "Print the authenticated user and the configured AI.",
+	run: func(ctx context.Context, e *env, args []string, _ any) error {
+		k, err := e.cfg.newClient(ctx, false)
+		if err != nil {
+			return err
+		}
+		defer k.Close()
+		if k.UserID == "" {
+			// Static API keys do not contain the user ID
+			if err = k.SetupUserAndPermissionsContext(ctx); err != nil {
+				return err
+			}
+		}
+
+		output := whoamiOutput{UserID: k.UserID, AIID: k.KindroidID, JWT: k.JWTAuth, BaseURL: k.BaseURL}
+		expires := "unknown"
+		if exp := k.TokenExpiresAt(); !exp.IsZero() {
+			output.TokenExpiresAt = exp.Format(time.RFC3339)
+			expires = output.TokenExpiresAt
+		}
+		rows := [][]string{
+			{"user_id", output.UserID},
+			{"ai_id", output.AIID},
+			{"jwt", strconv.FormatBool(output.JWT)},
+			{"token_expires_at", expires},
+			{"base_url", output.BaseURL},
+		}
+		return e.out.print(view{JSON: output, Header: []string{"KEY", "VALUE"}, Rows: rows, Text: keyValueText(rows)})
+	},
+}
+
+type whoamiOutput struct {
+	UserID         string `json:"user_id"`
+	AIID           string `json:"ai_id,omitempty"`
+	JWT            bool   `json:"jwt"`
+	TokenExpiresAt string `json:"token_expires_at,omitempty"`
+	BaseURL        string `json:"base_url"`
+}
+
+func keyValueText(rows [][]string) string {
+	var text strings.Builder
+	for _, row := range rows {
+		fmt.Fprintf(&text, "%s: %s\n", row[0], row[1])
+	}
+	return text.String()
+}
@@ -0,0 +1,218 @@
+// Package main
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/client"
+)
+
+// Environment variables read by kindroidctl. KINDROID_USER_ID is read by the client itself.
+const (
+	envConfig         = "KINDROID_CONFIG"
+	envAPIKey         = "KINDROID_API_KEY"
+	envAIID           = "KINDROID_AI_ID"
+	envBaseURL        = "KINDROID_BASE_URL"
+	envRefreshToken   = "KINDROID_REFRESH_TOKEN"
+	envFirebaseAPIKey = "KINDROID_FIREBASE_API_KEY"
+	envTokenFile      = "KINDROID_TOKEN_FILE"
+	envTokenURL       = "KINDROID_TOKEN_REFRESH_URL"
+	envOutput         = "KINDROID_OUTPUT"
+)
+
+// config holds the settings shared by all commands.
+// Values are read from the config file, then overridden by environment variables and finally by flags.
+type config struct {
+	APIKey         string   `json:"api_key,omitempty"`
+	AIID           string   `json:"ai_id,omitempty"`
+	UserID         string   `json:"user_id,omitempty"`
+	BaseURL        string   `json:"base_url,omitempty"`
+	RefreshToken   string   `json:"refresh_token,omitempty"`
+	FirebaseAPIKey string   `json:"firebase_api_key,omitempty"`
+	TokenFile      string   `json:"token_file,omitempty"`
+	TokenURL       string   `json:"token_refresh_url,omitempty"`
+	Timeout        duration `json:"timeout,omitempty"`
+	Output         string   `json:"output,omitempty"`
+
+	path    string
+	verbose bool
+}
+
+// duration is a time.Duration which is written as a string like "30s" in the config file.
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// defaultConfigPath returns the location of the config file if none is given.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "kindroidctl", "config.json")
+}
+
+// registerFlags adds the common flags to a command's flag set.
+func (c *config) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.path, "config", "", "config file (default $"+envConfig+" or "+defaultConfigPath()+")")
+	fs.StringVar(&c.APIKey, "api-key", "", "API key or JWT ($"+envAPIKey+")")
+	fs.StringVar(&c.AIID, "ai", "", "AI ID ($"+envAIID+")")
+	fs.StringVar(&c.UserID, "user", "", "user ID, needed for chat history with static API keys ($"+client.UserIDEnv+")")
+	fs.StringVar(&c.BaseURL, "base-url", "", "API base URL ($"+envBaseURL+")")
+	fs.StringVar(&c.RefreshToken, "refresh-token", "", "Firebase refresh token used to obtain JWTs ($"+envRefreshToken+")")
+	fs.StringVar(&c.FirebaseAPIKey, "firebase-api-key", "", "Firebase web API key for --refresh-token ($"+envFirebaseAPIKey+")")
+	fs.StringVar(&c.TokenFile, "token-file", "", "file containing the current JWT ($"+envTokenFile+")")
+	fs.Func("timeout", "HTTP request timeout, e.g. 30s", func(s string) error {
+		d, err := time.ParseDuration(s)
+		c.Timeout = duration(d)
+		return err
+	})
+	fs.StringVar(&c.Output, "output", "", "output format: text, table or json ($"+envOutput+")")
+	fs.BoolVar(&c.verbose, "verbose", false, "log requests to stderr")
+}
+
+// load completes the flag values with the environment and the config file.
+func (c *config) load(fs *flag.FlagSet, getenv func(string) string) error {
+	flags := *c
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	path, explicit := c.path, c.path != ""
+	if !explicit {
+		path, explicit = getenv(envConfig), getenv(envConfig) != ""
+	}
+	if !explicit {
+		path = defaultConfigPath()
+	}
+	*c = config{path: path, verbose: flags.verbose}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil && (explicit || !errors.Is(err, os.ErrNotExist)) {
+			return usageError{fmt.Errorf("failed to read config file: %w", err)}
+		}
+		if err == nil {
+			if err = json.Unmarshal(data, c); err != nil {
+				return usageError{fmt.Errorf("invalid config file %s: %w", path, err)}
+			}
+		}
+	}
+
+	for _, v := range []struct {
+		field *string
+		env   string
+		flag  string
+		value string
+	}{
+		{&c.APIKey, envAPIKey, "api-key", flags.APIKey},
+		{&c.AIID, envAIID, "ai", flags.AIID},
+		{&c.UserID, "", "user", flags.UserID},
+		{&c.BaseURL, envBaseURL, "base-url", flags.BaseURL},
+		{&c.RefreshToken, envRefreshToken, "refresh-token", flags.RefreshToken},
+		{&c.FirebaseAPIKey, envFirebaseAPIKey, "firebase-api-key", flags.FirebaseAPIKey},
+		{&c.TokenFile, envTokenFile, "token-file", flags.TokenFile},
+		{&c.TokenURL, envTokenURL, "", ""},
+		{&c.Output, envOutput, "output", flags.Output},
+	} {
+		if env := getenv(v.env); v.env != "" && env != "" {
+			*v.field = env
+		}
+		if set[v.flag] {
+			*v.field = v.value
+		}
+	}
+	if set["timeout"] {
+		c.Timeout = flags.Timeout
+	}
+
+	switch c.Output {
+	case "":
+		c.Output = outputText
+	case outputText, outputTable, outputJSON:
+	default:
+		return usageError{fmt.Errorf("unknown output format %q", c.Output)}
+	}
+	return nil
+}
+
+// newClient creates a client from the configuration.
+// With a refresh token, the ID token is obtained right away, so JWT-only commands like history are available.
+func (c *config) newClient(ctx context.Context, needsAI bool) (*client.KindroidAI, error) {
+	if needsAI && c.AIID == "" {
+		return nil, usageError{fmt.Errorf("no AI ID configured; use --ai or $%s", envAIID)}
+	}
+	opts := []client.Option{client.WithKindroidID(c.AIID), client.WithUserAgent("kindroidctl")}
+	if c.UserID != "" {
+		opts = append(opts, client.WithUserID(c.UserID))
+	}
+	if c.BaseURL != "" {
+		opts = append(opts, client.WithBaseURL(c.BaseURL))
+	}
+	if c.Timeout > 0 {
+		opts = append(opts, client.WithTimeout(time.Duration(c.Timeout)))
+	}
+	refresh := false
+	switch {
+	case c.TokenFile != "":
+		opts = append(opts, client.WithTokenSource(client.FileTokenSource(c.TokenFile)))
+	case c.RefreshToken != "":
+		opts = append(opts, client.WithRefreshToken(c.RefreshToken, c.FirebaseAPIKey))
+		if c.TokenURL != "" {
+			opts = append(opts, client.WithTokenRefreshURL(c.TokenURL))
+		}
+		refresh = true
+	case c.APIKey == "":
+		return nil, usageError{fmt.Errorf("no credentials configured; use --api-key or $%s", envAPIKey)}
+	}
+	if c.verbose {
+		opts = append(opts, client.WithLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+	}
+	k, err := client.New(c.APIKey, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if refresh {
+		if err = k.SetupUserAndPermissionsContext(ctx); err != nil {
+			k.Close()
+			return nil, err
+		}
+	}
+	return k, nil
+}
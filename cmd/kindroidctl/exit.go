@@ -0,0 +1,91 @@
+// Package main
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/client"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Exit codes of kindroidctl.
+const (
+	exitOK           = 0
+	exitError        = 1
+	exitUsage        = 2
+	exitUnauthorized = 3
+	exitSubscription = 4
+	exitRateLimited  = 5
+	exitNotFound     = 6
+	exitServer       = 7
+	exitTimeout      = 8
+	exitInterrupted  = 130
+)
+
+const exitCodeHelp = `Exit codes:
+  0    success
+  1    other error
+  2    invalid usage or configuration
+  3    unauthorized, or the command requires a JWT
+  4    subscription required
+  5    rate limited
+  6    not found
+  7    server error
+  8    timed out
+  130  interrupted
+`
+
+// usageError marks errors caused by invalid arguments or configuration.
+type usageError struct {
+	err error
+}
+
+func (e usageError) Error() string { return e.err.Error() }
+func (e usageError) Unwrap() error { return e.err }
+
+// exitCode maps an error to the exit code of the process.
+func exitCode(err error) int {
+	var usage usageError
+	var apiErr *client.APIError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &usage):
+		return exitUsage
+	case errors.Is(err, context.Canceled):
+		return exitInterrupted
+	case errors.Is(err, client.ErrUnauthorized), errors.Is(err, client.ErrJWTRequired):
+		return exitUnauthorized
+	case errors.Is(err, client.ErrSubscriptionRequired):
+		return exitSubscription
+	case errors.Is(err, client.ErrRateLimited):
+		return exitRateLimited
+	case errors.Is(err, client.ErrAudioNotReady), errors.Is(err, client.ErrMessageNotResolved),
+		errors.Is(err, context.DeadlineExceeded):
+		return exitTimeout
+	case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound, status.Code(err) == codes.NotFound:
+		return exitNotFound
+	case errors.As(err, &apiErr) && apiErr.StatusCode >= 500:
+		return exitServer
+	default:
+		return exitError
+	}
+}
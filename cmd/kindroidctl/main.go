@@ -0,0 +1,143 @@
+// Package main
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+)
+
+// command is a subcommand of kindroidctl.
+type command struct {
+	usage   string
+	summary string
+	// flags registers the command specific flags; it may be nil.
+	flags func(fs *flag.FlagSet) any
+	run   func(ctx context.Context, env *env, args []string, flags any) error
+}
+
+var commands = map[string]command{
+	"chat":         chatCommand,
+	"send":         sendCommand,
+	"break":        breakCommand,
+	"export":       exportCommand,
+	"history":      historyCommand,
+	"audio":        audioCommand,
+	"subscription": subscriptionCommand,
+	"whoami":       whoamiCommand,
+}
+
+// env is passed to the commands.
+type env struct {
+	cfg    *config
+	out    *printer
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr, os.Getenv)
+	stop()
+	os.Exit(code)
+}
+
+// run executes the command line and returns the exit code.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer, getenv func(string) string) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		printUsage(stderr)
+		if len(args) == 0 {
+			return exitUsage
+		}
+		return exitOK
+	}
+	name := args[0]
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "kindroidctl: unknown command %q\n\n", name)
+		printUsage(stderr)
+		return exitUsage
+	}
+
+	cfg := &config{}
+	fs := flag.NewFlagSet("kindroidctl "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: kindroidctl %s %s\n\n%s\n\nFlags:\n", name, cmd.usage, cmd.summary)
+		fs.PrintDefaults()
+	}
+	cfg.registerFlags(fs)
+	var flags any
+	if cmd.flags != nil {
+		flags = cmd.flags(fs)
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if err := cfg.load(fs, getenv); err != nil {
+		fmt.Fprintf(stderr, "kindroidctl: %v\n", err)
+		return exitCode(err)
+	}
+
+	e := &env{cfg: cfg, out: &printer{w: stdout, format: cfg.Output}, stdin: stdin, stdout: stdout, stderr: stderr}
+	if err := cmd.run(ctx, e, fs.Args(), flags); err != nil {
+		fmt.Fprintf(stderr, "kindroidctl %s: %v\n", name, err)
+		return exitCode(err)
+	}
+	return exitOK
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprint(w, "kindroidctl is a command-line client for the KindroidAI API.\n\nUsage: kindroidctl <command> [flags] [arguments]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-13s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintf(w, "\nRun \"kindroidctl <command> -h\" for the flags of a command.\n"+
+		"Settings are read from the config file, then $KINDROID_* environment variables, then flags.\n\n%s", exitCodeHelp)
+}
+
+// messageArgument joins the arguments to a message, reading it from stdin if there are none or the only one is "-".
+func messageArgument(e *env, args []string) (string, error) {
+	if len(args) == 0 || (len(args) == 1 && args[0] == "-") {
+		data, err := io.ReadAll(e.stdin)
+		if err != nil {
+			return "", err
+		}
+		args = []string{string(data)}
+	}
+	message := strings.TrimSpace(strings.Join(args, " "))
+	if message == "" {
+		return "", usageError{errors.New("message must not be empty")}
+	}
+	return message, nil
+}
@@ -0,0 +1,301 @@
+// Package main
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/client"
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	testUserID = "test_user_id"
+	testAIID   = "test_ai_id"
+)
+
+// fakeAPI records the requests to a fake KindroidAI API.
+type fakeAPI struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []map[string]any
+	auth     []string
+}
+
+func newFakeAPI(t *testing.T) *fakeAPI {
+	api := &fakeAPI{}
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		api.mu.Lock()
+		api.requests = append(api.requests, body)
+		api.auth = append(api.auth, r.Header.Get("Authorization"))
+		api.mu.Unlock()
+
+		switch r.URL.Path {
+		case "/send-message":
+			if body["message"] == "forbidden" {
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprintf(w, `{"reply": "You said: %s", "message_id": "reply_1"}`, body["message"])
+		case "/chat-break":
+		case "/check-user-subscription":
+			w.Write([]byte(`{"uid": "test_user_id", "status": "active", "isSubscribedBase": true, "subscriptionPlatformBase": "stripe"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(api.Close)
+	return api
+}
+
+func (api *fakeAPI) lastRequest() (map[string]any, string) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return api.requests[len(api.requests)-1], api.auth[len(api.auth)-1]
+}
+
+// runCLI runs kindroidctl with an isolated environment.
+func runCLI(t *testing.T, env map[string]string, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	if env == nil {
+		env = map[string]string{}
+	}
+	if _, ok := env[envConfig]; !ok {
+		// Never read the config file of the user running the tests
+		env[envConfig] = filepath.Join(t.TempDir(), "config.json")
+		require.NoError(t, os.WriteFile(env[envConfig], []byte("{}"), 0o600))
+	}
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr, func(key string) string { return env[key] })
+	return code, stdout.String(), stderr.String()
+}
+
+func TestSend(t *testing.T) {
+	api := newFakeAPI(t)
+	env := map[string]string{envAPIKey: "test_api_key", envAIID: testAIID, envBaseURL: api.URL}
+
+	code, stdout, stderr := runCLI(t, env, "", "send", "Hello", "there")
+	require.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "You said: Hello there\n", stdout)
+	request, auth := api.lastRequest()
+	assert.Equal(t, testAIID, request["ai_id"])
+	assert.Equal(t, "Bearer test_api_key", auth)
+
+	code, stdout, stderr = runCLI(t, env, "From stdin\n", "send", "--output", "json")
+	require.Equal(t, exitOK, code, stderr)
+	assert.JSONEq(t, `{"reply": "You said: From stdin", "message_id": "reply_1"}`, stdout)
+
+	code, stdout, stderr = runCLI(t, env, "", "send", "--output", "table", "Hi")
+	require.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "MESSAGE ID  REPLY\nreply_1     You said: Hi\n", stdout)
+
+	code, _, stderr = runCLI(t, env, "", "send", "forbidden")
+	assert.Equal(t, exitUnauthorized, code)
+	assert.Contains(t, stderr, "401")
+
+	code, _, _ = runCLI(t, env, "  \n", "send")
+	assert.Equal(t, exitUsage, code)
+}
+
+func TestChat(t *testing.T) {
+	api := newFakeAPI(t)
+	env := map[string]string{envAPIKey: "test_api_key", envAIID: testAIID, envBaseURL: api.URL}
+
+	code, stdout, stderr := runCLI(t, env, "Hello\n\n/break\n/break Welcome back!\nHow are you?\n/quit\nignored\n", "chat")
+	require.Equal(t, exitOK, code, stderr)
+	assert.Contains(t, stdout, "You said: Hello")
+	assert.Contains(t, stdout, "--- chat break ---\nWelcome back!\n")
+	assert.Contains(t, stdout, "You said: How are you?")
+	assert.NotContains(t, stdout, "ignored")
+	assert.Contains(t, stderr, "Usage: /break <greeting>")
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	assert.Len(t, api.requests, 3)
+	assert.Equal(t, "Welcome back!", api.requests[1]["greeting"])
+}
+
+func TestBreak(t *testing.T) {
+	api := newFakeAPI(t)
+	env := map[string]string{envAPIKey: "test_api_key", envBaseURL: api.URL}
+
+	code, stdout, stderr := runCLI(t, env, "", "break", "--ai", "other_ai", "--output", "json", "Hello again!")
+	require.Equal(t, exitOK, code, stderr)
+	assert.JSONEq(t, `{"ai_id": "other_ai", "greeting": "Hello again!"}`, stdout)
+	request, _ := api.lastRequest()
+	assert.Equal(t, "other_ai", request["ai_id"])
+	assert.Equal(t, "Hello again!", request["greeting"])
+
+	code, _, stderr = runCLI(t, env, "", "break", "Hello again!")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "no AI ID configured")
+}
+
+func TestSubscriptionAndWhoami(t *testing.T) {
+	api := newFakeAPI(t)
+	env := map[string]string{envAPIKey: "test_api_key", envBaseURL: api.URL}
+
+	code, stdout, stderr := runCLI(t, env, "", "subscription", "--output", "table")
+	require.Equal(t, exitOK, code, stderr)
+	assert.Contains(t, stdout, "status   active\n")
+	assert.Contains(t, stdout, "base     subscribed (stripe)\n")
+	assert.Contains(t, stdout, "addon1   not subscribed\n")
+
+	// The user ID of a static API key is looked up using the subscription endpoint
+	code, stdout, stderr = runCLI(t, env, "", "whoami", "--output", "json")
+	require.Equal(t, exitOK, code, stderr)
+	assert.JSONEq(t, fmt.Sprintf(`{"user_id": %q, "jwt": false, "base_url": %q}`, testUserID, api.URL), stdout)
+
+	env[envAPIKey] = testutil.JWT("jwt_user")
+	env[envAIID] = testAIID
+	code, stdout, stderr = runCLI(t, env, "", "whoami")
+	require.Equal(t, exitOK, code, stderr)
+	assert.Contains(t, stdout, "user_id: jwt_user\nai_id: test_ai_id\njwt: true\ntoken_expires_at: unknown\n")
+}
+
+func TestRefreshTokenLogin(t *testing.T) {
+	api := newFakeAPI(t)
+	secureToken, requests := testutil.NewSecureTokenServer(t, "refresh_token", testutil.JWT(testUserID))
+
+	// Without an API key, the ID token is obtained before running JWT-only commands
+	cfg := &config{AIID: testAIID, BaseURL: api.URL, RefreshToken: "refresh_token", TokenURL: secureToken.URL}
+	k, err := cfg.newClient(context.Background(), true)
+	require.NoError(t, err)
+	defer k.Close()
+	assert.True(t, k.JWTAuth)
+	assert.Equal(t, testUserID, k.UserID)
+	assert.Equal(t, int32(1), requests.Load())
+
+	env := map[string]string{envRefreshToken: "refresh_token", envTokenURL: secureToken.URL, envBaseURL: api.URL}
+	code, stdout, stderr := runCLI(t, env, "", "whoami", "--output", "json")
+	require.Equal(t, exitOK, code, stderr)
+	assert.Contains(t, stdout, `"jwt": true`)
+
+	code, _, stderr = runCLI(t, env, "", "send", "--ai", testAIID, "Hello")
+	require.Equal(t, exitOK, code, stderr)
+	_, auth := api.lastRequest()
+	assert.Equal(t, "Bearer "+testutil.JWT(testUserID), auth)
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	api := newFakeAPI(t)
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf(
+		`{"api_key": "file_key", "ai_id": "file_ai", "base_url": %q, "output": "json", "timeout": "10s"}`, api.URL)), 0o600))
+
+	code, stdout, stderr := runCLI(t, map[string]string{envConfig: path}, "", "send", "Hi")
+	require.Equal(t, exitOK, code, stderr)
+	assert.Contains(t, stdout, `"reply"`)
+	request, auth := api.lastRequest()
+	assert.Equal(t, "file_ai", request["ai_id"])
+	assert.Equal(t, "Bearer file_key", auth)
+
+	// Environment variables override the file, flags override both
+	env := map[string]string{envAPIKey: "env_key", envAIID: "env_ai", envOutput: "text"}
+	code, stdout, stderr = runCLI(t, env, "", "send", "--config", path, "--ai", "flag_ai", "Hi")
+	require.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "You said: Hi\n", stdout)
+	request, auth = api.lastRequest()
+	assert.Equal(t, "flag_ai", request["ai_id"])
+	assert.Equal(t, "Bearer env_key", auth)
+
+	code, _, stderr = runCLI(t, nil, "", "send", "--config", filepath.Join(t.TempDir(), "missing.json"), "Hi")
+	assert.Equal(t, exitUsage, code, "an explicit config file must exist")
+	assert.Contains(t, stderr, "failed to read config file")
+
+	code, _, _ = runCLI(t, map[string]string{envConfig: path}, "", "send", "--output", "yaml", "Hi")
+	assert.Equal(t, exitUsage, code)
+}
+
+func TestUsage(t *testing.T) {
+	code, _, stderr := runCLI(t, nil, "")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "subscription")
+
+	code, _, stderr = runCLI(t, nil, "", "unknown")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, `unknown command "unknown"`)
+
+	code, _, _ = runCLI(t, nil, "", "history", "--limit", "many")
+	assert.Equal(t, exitUsage, code)
+
+	code, _, stderr = runCLI(t, nil, "", "send", "--ai", testAIID, "Hi")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "no credentials configured")
+
+	code, _, _ = runCLI(t, nil, "", "help")
+	assert.Equal(t, exitOK, code)
+}
+
+func TestExitCode(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		code int
+	}{
+		{nil, exitOK},
+		{errors.New("boom"), exitError},
+		{usageError{errors.New("bad flag")}, exitUsage},
+		{fmt.Errorf("wrapped: %w", &client.APIError{StatusCode: http.StatusUnauthorized}), exitUnauthorized},
+		{client.ErrJWTRequired, exitUnauthorized},
+		{&client.APIError{StatusCode: http.StatusPaymentRequired}, exitSubscription},
+		{&client.APIError{StatusCode: http.StatusTooManyRequests}, exitRateLimited},
+		{&client.APIError{StatusCode: http.StatusNotFound}, exitNotFound},
+		{fmt.Errorf("failed to fetch message: %w", status.Error(codes.NotFound, "no such document")), exitNotFound},
+		{&client.APIError{StatusCode: http.StatusBadGateway}, exitServer},
+		{fmt.Errorf("%w: %w", client.ErrAudioNotReady, context.DeadlineExceeded), exitTimeout},
+		{context.Canceled, exitInterrupted},
+	} {
+		assert.Equal(t, tt.code, exitCode(tt.err), "%v", tt.err)
+	}
+}
+
+func TestExportValidation(t *testing.T) {
+	env := map[string]string{envAPIKey: "test_api_key", envAIID: testAIID}
+
+	code, _, stderr := runCLI(t, env, "", "export", "--format", "pdf")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, `unknown export format "pdf"`)
+
+	code, _, _ = runCLI(t, env, "", "export", "--audio", "inline")
+	assert.Equal(t, exitUsage, code)
+
+	code, _, _ = runCLI(t, env, "", "export", "--tz", "Mars/Olympus_Mons")
+	assert.Equal(t, exitUsage, code)
+
+	// Reading the history requires a JWT
+	code, _, stderr = runCLI(t, env, "", "export", "-o", filepath.Join(t.TempDir(), "chat.md"))
+	assert.Equal(t, exitUnauthorized, code)
+	assert.Contains(t, stderr, "JWT")
+}
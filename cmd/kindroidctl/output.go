@@ -0,0 +1,75 @@
+// Package main
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// Output formats selected with --output.
+const (
+	outputText  = "text"
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// view is the result of a command in all output formats.
+type view struct {
+	// JSON is marshalled for --output json.
+	JSON any
+	// Header and Rows are printed for --output table. If Header is nil, Text is printed instead.
+	Header []string
+	Rows   [][]string
+	// Text is printed for --output text.
+	Text string
+}
+
+// printer writes command results in the configured output format.
+type printer struct {
+	w      io.Writer
+	format string
+}
+
+func (p *printer) print(v view) error {
+	switch {
+	case p.format == outputJSON:
+		enc := json.NewEncoder(p.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v.JSON)
+	case p.format == outputTable && v.Header != nil:
+		tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(v.Header, "\t"))
+		for _, row := range v.Rows {
+			for i, cell := range row {
+				// Keep multi-line messages on a single row
+				row[i] = strings.Join(strings.Fields(cell), " ")
+			}
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	default:
+		if v.Text == "" {
+			return nil
+		}
+		_, err := fmt.Fprintln(p.w, strings.TrimRight(v.Text, "\n"))
+		return err
+	}
+}
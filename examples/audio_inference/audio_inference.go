@@ -21,35 +21,28 @@ func main() {
 	}
 
 	kindroidClient := client.NewKindroidAI(apiKey, aiID)
+	defer kindroidClient.Close()
 
 	// Setup User
 	errUser := kindroidClient.SetupUserAndPermissions()
 	if errUser != nil {
 		log.Fatal("Failed to set up user")
 	}
-	fmt.Printf("Authenticated as UserID: %s\n", kindroidClient.UserID)
+	fmt.Printf("Authenticated as UserID: %s (source: %s)\n", kindroidClient.UserID, kindroidClient.UserIDSource)
 
 	ctx := context.Background()
-	messages, err := kindroidClient.GetChatHistory(ctx, aiID, 10) // Get last messages
+	exchange, err := kindroidClient.SendMessageAndResolve(ctx, client.SendMessageOptions{
+		AIID:    aiID,
+		Message: "Please say something nice for an audio test!",
+	}, client.ResolveOptions{})
 	if err != nil {
-		log.Fatalf("Failed to get chat history: %v", err)
+		log.Fatalf("Failed to send and resolve message: %v", err)
 	}
 
-	// Fetch first AI message we find
-	var kindroidMessageForAudio *client.ChatMessage
-	for _, msg := range messages {
-		if msg.Sender != "ai" {
-			continue
-		}
-		fmt.Println("Found AI message for Inference Test")
-		fmt.Printf("[%s] %s: %s\n", msg.GetTime().Format("2006-01-02 15:04:05"), msg.Sender, msg.Message)
-		kindroidMessageForAudio = msg
-		break
-	}
-
-	if kindroidMessageForAudio == nil {
-		log.Fatal("No AI message found for Inference Test")
-	}
+	// The reply's message ID is known, so audio can be requested for it directly
+	kindroidMessageForAudio := exchange.Reply
+	fmt.Println("Received AI reply for Inference Test")
+	fmt.Printf("[%s] %s: %s\n", kindroidMessageForAudio.GetTime().Format("2006-01-02 15:04:05"), kindroidMessageForAudio.Sender, kindroidMessageForAudio.Message)
 
 	audioBytes, errGenAudio := kindroidClient.AudioInference(kindroidMessageForAudio.ID)
 	if errGenAudio != nil {
@@ -21,13 +21,14 @@ func main() {
 	}
 
 	kindroidClient := client.NewKindroidAI(apiKey, aiID)
+	defer kindroidClient.Close()
 
 	// Setup User
 	errUser := kindroidClient.SetupUserAndPermissions()
 	if errUser != nil {
 		log.Fatal("Failed to set up user")
 	}
-	fmt.Printf("Authenticated as UserID: %s\n", kindroidClient.UserID)
+	fmt.Printf("Authenticated as UserID: %s (source: %s)\n", kindroidClient.UserID, kindroidClient.UserIDSource)
 
 	ctx := context.Background()
 	messages, err := kindroidClient.GetChatHistory(ctx, aiID, 10) // Get last 10 messages
@@ -0,0 +1,336 @@
+// Package export
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package export
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/client"
+)
+
+// Format selects the output format of a Writer.
+type Format string
+
+const (
+	// FormatJSONL writes one JSON object per message.
+	FormatJSONL Format = "jsonl"
+	// FormatMarkdown writes a Markdown transcript.
+	FormatMarkdown Format = "markdown"
+	// FormatHTML writes a standalone HTML page.
+	FormatHTML Format = "html"
+	// FormatCSV writes a CSV file with a header row.
+	FormatCSV Format = "csv"
+)
+
+// Formats lists the supported formats.
+var Formats = []Format{FormatJSONL, FormatMarkdown, FormatHTML, FormatCSV}
+
+// AudioMode selects how the audio of messages is exported.
+type AudioMode int
+
+const (
+	// AudioNone exports the text only.
+	AudioNone AudioMode = iota
+	// AudioLink downloads the audio into Options.AudioDir and links the files.
+	AudioLink
+	// AudioEmbed downloads the audio and embeds it as base64, as a data URI in Markdown and HTML.
+	AudioEmbed
+)
+
+// AudioSource provides the audio of messages; *client.KindroidAI implements it.
+type AudioSource interface {
+	AudioStream(ctx context.Context, messageID string) (io.ReadCloser, client.AudioInfo, error)
+}
+
+// Options configures a Writer. The zero value exports the text of all messages with local timestamps.
+type Options struct {
+	// Title is the heading of Markdown and HTML transcripts. Defaults to "Chat History".
+	Title string
+	// UserLabel and AILabel name the senders. They default to "User" and "AI"; other senders are shown as stored.
+	UserLabel string
+	AILabel   string
+	// TimeFormat is the time.Format layout of timestamps.
+	// Defaults to time.RFC3339 for JSONL and CSV, and to "2006-01-02 15:04:05" for transcripts.
+	TimeFormat string
+	// Location is the time zone of timestamps. Defaults to time.Local.
+	Location *time.Location
+	// After and Before restrict the export to messages sent strictly within this range, if set.
+	After  time.Time
+	Before time.Time
+	// Audio selects how audio is exported; AudioLink and AudioEmbed require AudioSource.
+	Audio       AudioMode
+	AudioSource AudioSource
+	// AudioDir is the directory the audio files are written to for AudioLink.
+	// Links use the directory as given, so a path relative to the exported file keeps the export portable.
+	AudioDir string
+	// NewestFirst makes Export write the newest message first, reading the history only once.
+	NewestFirst bool
+}
+
+// entry is a message prepared for an encoder.
+type entry struct {
+	*client.ChatMessage
+	Label string
+	Time  string
+	// AudioFile is the link to the downloaded audio for AudioLink.
+	AudioFile string
+	// AudioData is the base64 encoded audio for AudioEmbed, and AudioType its MIME type.
+	AudioData string
+	AudioType string
+}
+
+// encoder writes entries in a specific format.
+type encoder interface {
+	begin() error
+	write(e *entry) error
+	end() error
+}
+
+// Writer writes chat messages in one of the export formats. Messages are written as they are passed to Write,
+// in the given order; Close completes the output.
+type Writer struct {
+	opts    Options
+	enc     encoder
+	started bool
+	count   int
+}
+
+// NewWriter creates a Writer writing the given format to w.
+func NewWriter(w io.Writer, format Format, opts Options) (*Writer, error) {
+	if opts.Audio != AudioNone && opts.AudioSource == nil {
+		return nil, errors.New("exporting audio requires an audio source")
+	}
+	if opts.Audio == AudioLink && opts.AudioDir == "" {
+		return nil, errors.New("linking audio requires an audio directory")
+	}
+	if opts.Title == "" {
+		opts.Title = "Chat History"
+	}
+	if opts.UserLabel == "" {
+		opts.UserLabel = "User"
+	}
+	if opts.AILabel == "" {
+		opts.AILabel = "AI"
+	}
+	if opts.Location == nil {
+		opts.Location = time.Local
+	}
+
+	var enc encoder
+	transcriptTime := "2006-01-02 15:04:05"
+	switch format {
+	case FormatJSONL:
+		enc, transcriptTime = newJSONLEncoder(w), time.RFC3339
+	case FormatMarkdown:
+		enc = newMarkdownEncoder(w, opts.Title)
+	case FormatHTML:
+		enc = newHTMLEncoder(w, opts.Title)
+	case FormatCSV:
+		enc, transcriptTime = newCSVEncoder(w, opts.Audio != AudioNone), time.RFC3339
+	default:
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+	if opts.TimeFormat == "" {
+		opts.TimeFormat = transcriptTime
+	}
+	return &Writer{opts: opts, enc: enc}, nil
+}
+
+// Write writes a message, unless it is outside of the configured date range.
+// With audio enabled, the audio of messages having one is downloaded first.
+func (w *Writer) Write(ctx context.Context, message *client.ChatMessage) error {
+	t := message.GetTime()
+	if (!w.opts.After.IsZero() && !t.After(w.opts.After)) || (!w.opts.Before.IsZero() && !t.Before(w.opts.Before)) {
+		return nil
+	}
+	if err := w.begin(); err != nil {
+		return err
+	}
+
+	e := &entry{ChatMessage: message, Label: w.label(message.Sender), Time: t.In(w.opts.Location).Format(w.opts.TimeFormat)}
+	if w.opts.Audio != AudioNone && message.Audio != "" && message.Audio != client.DecryptionFailed {
+		if err := w.addAudio(ctx, e); err != nil {
+			return fmt.Errorf("failed to export audio of message %s: %w", message.ID, err)
+		}
+	}
+	w.count++
+	return w.enc.write(e)
+}
+
+// Count returns the number of messages written.
+func (w *Writer) Count() int {
+	return w.count
+}
+
+// Close completes the output. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if err := w.begin(); err != nil {
+		return err
+	}
+	return w.enc.end()
+}
+
+func (w *Writer) begin() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+	return w.enc.begin()
+}
+
+func (w *Writer) label(sender string) string {
+	switch sender {
+	case client.SenderUser:
+		return w.opts.UserLabel
+	case client.SenderAI:
+		return w.opts.AILabel
+	default:
+		return sender
+	}
+}
+
+// addAudio downloads the audio of the entry's message and links or embeds it.
+func (w *Writer) addAudio(ctx context.Context, e *entry) error {
+	stream, info, err := w.opts.AudioSource.AudioStream(ctx, e.ID)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	if w.opts.Audio == AudioEmbed {
+		data, err := io.ReadAll(stream)
+		if err != nil {
+			return err
+		}
+		e.AudioData, e.AudioType = base64.StdEncoding.EncodeToString(data), info.ContentType
+		return nil
+	}
+
+	if err = os.MkdirAll(w.opts.AudioDir, 0o755); err != nil {
+		return err
+	}
+	name := filepath.Base(e.ID) + ".mp3"
+	file, err := os.Create(filepath.Join(w.opts.AudioDir, name))
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(file, stream); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	e.AudioFile, e.AudioType = path.Join(filepath.ToSlash(w.opts.AudioDir), name), info.ContentType
+	return nil
+}
+
+// pageLister lists the chat history page by page, newest first; *client.KindroidAI implements it.
+type pageLister interface {
+	ListChatMessages(ctx context.Context, aiID string, opts client.ListOptions) (*client.ChatMessagePage, error)
+}
+
+// Export writes the complete chat history of the client's AI in the given format and returns the number
+// of exported messages. The date range of opts is applied when listing the messages.
+//
+// Messages are written as the history is paged through, so memory use does not grow with the history.
+// With opts.NewestFirst, every page is written as it arrives. Otherwise, since the history can only be
+// listed newest first, Export pages through it twice: the first pass only records where each page starts,
+// and the second fetches the pages again from the oldest and writes each one reversed. This keeps one page
+// and one cursor per page in memory, at the cost of reading the history twice. Messages sent after the
+// export started are not exported.
+func Export(ctx context.Context, k *client.KindroidAI, w io.Writer, format Format, opts Options) (int, error) {
+	return exportHistory(ctx, k, k.KindroidID, w, format, opts)
+}
+
+func exportHistory(ctx context.Context, lister pageLister, aiID string, w io.Writer, format Format, opts Options) (int, error) {
+	writer, err := NewWriter(w, format, opts)
+	if err != nil {
+		return 0, err
+	}
+	listOptions := client.ListOptions{After: opts.After, Before: opts.Before}
+	listPage := func(token string) (*client.ChatMessagePage, error) {
+		listOptions.PageToken = token
+		page, err := lister.ListChatMessages(ctx, aiID, listOptions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list chat messages: %w", err)
+		}
+		return page, nil
+	}
+
+	if opts.NewestFirst {
+		for token := ""; ; {
+			page, err := listPage(token)
+			if err != nil {
+				return writer.Count(), err
+			}
+			for _, message := range page.Messages {
+				if err = writer.Write(ctx, message); err != nil {
+					return writer.Count(), err
+				}
+			}
+			if token = page.NextPageToken; token == "" {
+				return writer.Count(), writer.Close()
+			}
+		}
+	}
+
+	// Messages sent during the export would shift the newest page between the passes,
+	// so the export is limited to the messages present when it starts
+	newest, err := lister.ListChatMessages(ctx, aiID, client.ListOptions{PageSize: 1, After: opts.After, Before: opts.Before})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list chat messages: %w", err)
+	}
+	if len(newest.Messages) == 0 {
+		return 0, writer.Close()
+	}
+	listOptions.Before = newest.Messages[0].GetTime().Add(time.Millisecond)
+
+	tokens := []string{""}
+	for {
+		page, err := listPage(tokens[len(tokens)-1])
+		if err != nil {
+			return 0, err
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		tokens = append(tokens, page.NextPageToken)
+	}
+	for i := len(tokens) - 1; i >= 0; i-- {
+		page, err := listPage(tokens[i])
+		if err != nil {
+			return writer.Count(), err
+		}
+		slices.Reverse(page.Messages)
+		for _, message := range page.Messages {
+			if err = writer.Write(ctx, message); err != nil {
+				return writer.Count(), err
+			}
+		}
+	}
+	return writer.Count(), writer.Close()
+}
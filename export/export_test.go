@@ -0,0 +1,273 @@
+// Package export
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// 2024-03-01 12:00:00 UTC
+const baseTimestamp = 1709294400000
+
+var testMessages = []*client.ChatMessage{
+	{ID: "m1", Sender: client.SenderUser, Message: "Hi, <b>you</b> & \"friends\"", Timestamp: baseTimestamp},
+	{ID: "m2", Sender: client.SenderAI, Message: "Hello!\nHow are you?", Timestamp: baseTimestamp + 60_000, Audio: "https://storage.example.com/m2.mp3"},
+	{ID: "m3", Sender: client.SenderUser, Message: "", Timestamp: baseTimestamp + 120_000},
+	{ID: "m4", Sender: client.SenderAI, Message: client.DecryptionFailed, Timestamp: baseTimestamp + 180_000, Audio: client.DecryptionFailed},
+}
+
+// fakeAudio serves fixed audio for every message and records the requested IDs.
+type fakeAudio struct {
+	requested []string
+	fail      bool
+}
+
+func (f *fakeAudio) AudioStream(_ context.Context, messageID string) (io.ReadCloser, client.AudioInfo, error) {
+	f.requested = append(f.requested, messageID)
+	if f.fail {
+		return nil, client.AudioInfo{}, errors.New("download failed")
+	}
+	return io.NopCloser(strings.NewReader("ID3-" + messageID)), client.AudioInfo{MessageID: messageID, ContentType: "audio/mpeg"}, nil
+}
+
+func write(t *testing.T, format Format, opts Options, messages ...*client.ChatMessage) string {
+	t.Helper()
+	var out bytes.Buffer
+	w, err := NewWriter(&out, format, opts)
+	require.NoError(t, err)
+	for _, message := range messages {
+		require.NoError(t, w.Write(context.Background(), message))
+	}
+	require.NoError(t, w.Close())
+	return out.String()
+}
+
+func TestJSONL(t *testing.T) {
+	out := write(t, FormatJSONL, Options{Location: time.FixedZone("CET", 3600), AILabel: "Aria"}, testMessages...)
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 4)
+	assert.JSONEq(t, `{"id": "m1", "time": "2024-03-01T13:00:00+01:00", "timestamp": 1709294400000, "sender": "user", "label": "User",
+		"message": "Hi, <b>you</b> & \"friends\""}`, lines[0])
+	var message jsonlMessage
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &message))
+	assert.Equal(t, "Aria", message.Label)
+	assert.Equal(t, "Hello!\nHow are you?", message.Message)
+	assert.Empty(t, message.AudioFile, "audio is not exported by default")
+}
+
+func TestCSV(t *testing.T) {
+	audio := &fakeAudio{}
+	dir := t.TempDir()
+	out := write(t, FormatCSV, Options{Location: time.UTC, Audio: AudioLink, AudioSource: audio, AudioDir: dir}, testMessages...)
+
+	records, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 5)
+	assert.Equal(t, []string{"id", "time", "sender", "label", "message", "audio"}, records[0])
+	assert.Equal(t, []string{"m1", "2024-03-01T12:00:00Z", "user", "User", "Hi, <b>you</b> & \"friends\"", ""}, records[1])
+	assert.Equal(t, "Hello!\nHow are you?", records[2][4])
+	assert.Equal(t, filepath.ToSlash(filepath.Join(dir, "m2.mp3")), records[2][5])
+	assert.Equal(t, []string{"m3", "2024-03-01T12:02:00Z", "user", "User", "", ""}, records[3])
+	assert.Equal(t, client.DecryptionFailed, records[4][4])
+	assert.Equal(t, "", records[4][5])
+
+	// Only the valid audio URL is downloaded
+	assert.Equal(t, []string{"m2"}, audio.requested)
+	data, err := os.ReadFile(filepath.Join(dir, "m2.mp3"))
+	require.NoError(t, err)
+	assert.Equal(t, "ID3-m2", string(data))
+}
+
+func TestMarkdown(t *testing.T) {
+	out := write(t, FormatMarkdown, Options{Title: "Aria", Location: time.UTC, TimeFormat: "Jan 2, 15:04", Audio: AudioEmbed, AudioSource: &fakeAudio{}},
+		testMessages[:3]...)
+	assert.Equal(t, `# Aria
+
+**User** · Mar 1, 12:00
+
+Hi, \<b\>you\</b\> \& "friends"
+
+**AI** · Mar 1, 12:01
+
+Hello!  
+How are you?
+
+<audio controls src="data:audio/mpeg;base64,SUQzLW0y"></audio>
+
+**User** · Mar 1, 12:02
+
+*(empty message)*
+`, out)
+
+	// Chat text, labels and the title cannot inject markup
+	out = write(t, FormatMarkdown, Options{Title: "# <Aria>", AILabel: "**Aria**", Location: time.UTC, TimeFormat: "15:04"},
+		&client.ChatMessage{ID: "x", Sender: client.SenderAI, Timestamp: baseTimestamp,
+			Message: "<script>alert(1)</script>\n# Heading\n- item\n2. item\n===\n[link](https://example.com) *bold* `code` a_b"})
+	assert.Equal(t, `# \# \<Aria\>
+
+**\*\*Aria\*\*** · 12:00
+
+\<script\>alert(1)\</script\>  
+\# Heading  
+\- item  
+2\. item  
+\===  
+\[link\](https://example.com) \*bold\* \`+"`"+`code\`+"`"+` a\_b
+`, out)
+}
+
+func TestHTML(t *testing.T) {
+	out := write(t, FormatHTML, Options{Title: "Chat with <Aria>", Location: time.UTC, Audio: AudioEmbed, AudioSource: &fakeAudio{}}, testMessages...)
+	assert.True(t, strings.HasPrefix(out, "<!DOCTYPE html>"))
+	assert.True(t, strings.HasSuffix(out, "</body>\n</html>\n"))
+	assert.Contains(t, out, "<title>Chat with &lt;Aria&gt;</title>")
+	assert.Contains(t, out, `<div class="text">Hi, &lt;b&gt;you&lt;/b&gt; &amp; &#34;friends&#34;</div>`)
+	assert.Contains(t, out, `<div class="message ai" id="m2">`)
+	assert.Contains(t, out, `<div class="meta"><strong>AI</strong> · 2024-03-01 12:01:00</div>`)
+	assert.Contains(t, out, `<audio controls src="data:audio/mpeg;base64,SUQzLW0y"></audio>`)
+	assert.Equal(t, 1, strings.Count(out, "<audio"))
+
+	// An empty export is still a complete page
+	out = write(t, FormatHTML, Options{})
+	assert.Contains(t, out, "<h1>Chat History</h1>\n</body>")
+}
+
+func TestDateRange(t *testing.T) {
+	start := time.UnixMilli(baseTimestamp + 30_000)
+	out := write(t, FormatJSONL, Options{After: start, Before: start.Add(2 * time.Minute)}, testMessages...)
+	assert.Equal(t, 2, strings.Count(out, "\n"))
+	assert.Contains(t, out, `"id":"m2"`)
+	assert.Contains(t, out, `"id":"m3"`)
+}
+
+// fakeHistory lists messages newest first in pages of pageSize, using the index of the next message as page token.
+// onList is called before every listing.
+type fakeHistory struct {
+	messages []*client.ChatMessage // oldest first
+	pageSize int
+	onList   func()
+	listed   int
+}
+
+func (f *fakeHistory) ListChatMessages(_ context.Context, aiID string, opts client.ListOptions) (*client.ChatMessagePage, error) {
+	if f.onList != nil {
+		f.onList()
+	}
+	f.listed++
+	var matching []*client.ChatMessage
+	for i := len(f.messages) - 1; i >= 0; i-- {
+		t := f.messages[i].GetTime()
+		if (opts.After.IsZero() || t.After(opts.After)) && (opts.Before.IsZero() || t.Before(opts.Before)) {
+			matching = append(matching, f.messages[i])
+		}
+	}
+	start := 0
+	if opts.PageToken != "" {
+		// Cursor semantics: continue after the message with the given ID
+		for i, message := range matching {
+			if message.ID == opts.PageToken {
+				start = i + 1
+			}
+		}
+	}
+	size := f.pageSize
+	if opts.PageSize > 0 {
+		size = opts.PageSize
+	}
+	end := min(start+size, len(matching))
+	page := &client.ChatMessagePage{Messages: slices.Clone(matching[start:end])}
+	if end < len(matching) {
+		page.NextPageToken = matching[end-1].ID
+	}
+	return page, nil
+}
+
+func TestExportHistory(t *testing.T) {
+	var messages []*client.ChatMessage
+	for i := range 8 {
+		messages = append(messages, &client.ChatMessage{ID: fmt.Sprintf("m%d", i), Sender: client.SenderAI, Message: "text", Timestamp: baseTimestamp + int64(i)*1000})
+	}
+	ids := func(out string) []string {
+		var ids []string
+		for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+			var message jsonlMessage
+			require.NoError(t, json.Unmarshal([]byte(line), &message))
+			ids = append(ids, message.ID)
+		}
+		return ids
+	}
+
+	// Oldest first; messages arriving after the export started are left out without shifting the pages
+	history := &fakeHistory{messages: slices.Clone(messages), pageSize: 3}
+	history.onList = func() {
+		history.messages = append(history.messages, &client.ChatMessage{ID: fmt.Sprintf("new%d", history.listed), Timestamp: baseTimestamp + 100_000 + int64(history.listed)})
+	}
+	var out bytes.Buffer
+	count, err := exportHistory(context.Background(), history, "ai", &out, FormatJSONL, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, 9, count)
+	assert.Equal(t, []string{"m0", "m1", "m2", "m3", "m4", "m5", "m6", "m7", "new0"}, ids(out.String()))
+
+	// Newest first in a single pass
+	history = &fakeHistory{messages: slices.Clone(messages), pageSize: 3}
+	out.Reset()
+	count, err = exportHistory(context.Background(), history, "ai", &out, FormatJSONL, Options{NewestFirst: true, After: time.UnixMilli(baseTimestamp)})
+	require.NoError(t, err)
+	assert.Equal(t, 7, count)
+	assert.Equal(t, []string{"m7", "m6", "m5", "m4", "m3", "m2", "m1"}, ids(out.String()))
+	assert.Equal(t, 3, history.listed)
+
+	// An empty history still produces a complete document
+	out.Reset()
+	count, err = exportHistory(context.Background(), &fakeHistory{pageSize: 3}, "ai", &out, FormatHTML, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+	assert.Contains(t, out.String(), "</html>")
+}
+
+func TestWriterErrors(t *testing.T) {
+	_, err := NewWriter(io.Discard, "yaml", Options{})
+	assert.Error(t, err)
+	_, err = NewWriter(io.Discard, FormatJSONL, Options{Audio: AudioEmbed})
+	assert.Error(t, err)
+	_, err = NewWriter(io.Discard, FormatJSONL, Options{Audio: AudioLink, AudioSource: &fakeAudio{}})
+	assert.Error(t, err)
+
+	w, err := NewWriter(io.Discard, FormatJSONL, Options{Audio: AudioEmbed, AudioSource: &fakeAudio{fail: true}})
+	require.NoError(t, err)
+	err = w.Write(context.Background(), testMessages[1])
+	assert.ErrorContains(t, err, "m2")
+	assert.Equal(t, 0, w.Count())
+}
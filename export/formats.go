@@ -0,0 +1,243 @@
+// Package export
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+// jsonlEncoder writes one JSON object per line.
+type jsonlEncoder struct {
+	enc *json.Encoder
+}
+
+type jsonlMessage struct {
+	ID        string `json:"id"`
+	Time      string `json:"time"`
+	Timestamp int64  `json:"timestamp"`
+	Sender    string `json:"sender"`
+	Label     string `json:"label"`
+	Message   string `json:"message"`
+	AudioFile string `json:"audio_file,omitempty"`
+	AudioData string `json:"audio_data,omitempty"`
+	AudioType string `json:"audio_type,omitempty"`
+}
+
+func newJSONLEncoder(w io.Writer) *jsonlEncoder {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &jsonlEncoder{enc: enc}
+}
+
+func (e *jsonlEncoder) begin() error { return nil }
+func (e *jsonlEncoder) end() error   { return nil }
+
+func (e *jsonlEncoder) write(m *entry) error {
+	return e.enc.Encode(jsonlMessage{
+		ID:        m.ID,
+		Time:      m.Time,
+		Timestamp: m.Timestamp,
+		Sender:    m.Sender,
+		Label:     m.Label,
+		Message:   m.Message,
+		AudioFile: m.AudioFile,
+		AudioData: m.AudioData,
+		AudioType: m.AudioType,
+	})
+}
+
+// csvEncoder writes a header row and one row per message.
+type csvEncoder struct {
+	w     *csv.Writer
+	audio bool
+}
+
+func newCSVEncoder(w io.Writer, audio bool) *csvEncoder {
+	return &csvEncoder{w: csv.NewWriter(w), audio: audio}
+}
+
+func (e *csvEncoder) begin() error {
+	header := []string{"id", "time", "sender", "label", "message"}
+	if e.audio {
+		header = append(header, "audio")
+	}
+	return e.w.Write(header)
+}
+
+func (e *csvEncoder) write(m *entry) error {
+	row := []string{m.ID, m.Time, m.Sender, m.Label, m.Message}
+	if e.audio {
+		audio := m.AudioFile
+		if m.AudioData != "" {
+			audio = dataURI(m)
+		}
+		row = append(row, audio)
+	}
+	if err := e.w.Write(row); err != nil {
+		return err
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvEncoder) end() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// markdownEncoder writes a transcript with one section per message.
+type markdownEncoder struct {
+	w     io.Writer
+	title string
+}
+
+func newMarkdownEncoder(w io.Writer, title string) *markdownEncoder {
+	return &markdownEncoder{w: w, title: title}
+}
+
+func (e *markdownEncoder) begin() error {
+	_, err := fmt.Fprintf(e.w, "# %s\n", escapeMarkdown(e.title))
+	return err
+}
+
+func (e *markdownEncoder) write(m *entry) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n**%s** · %s\n\n", escapeMarkdown(m.Label), escapeMarkdown(m.Time))
+	if text := strings.TrimSpace(m.Message); text != "" {
+		// Keep line breaks within a message
+		lines := strings.Split(text, "\n")
+		for i, line := range lines {
+			lines[i] = escapeMarkdown(line)
+		}
+		b.WriteString(strings.Join(lines, "  \n"))
+		b.WriteString("\n")
+	} else {
+		b.WriteString("*(empty message)*\n")
+	}
+	switch {
+	case m.AudioFile != "":
+		fmt.Fprintf(&b, "\n[▶ Audio](<%s>)\n", markdownURLReplacer.Replace(m.AudioFile))
+	case m.AudioData != "":
+		fmt.Fprintf(&b, "\n<audio controls src=\"%s\"></audio>\n", dataURI(m))
+	}
+	_, err := io.WriteString(e.w, b.String())
+	return err
+}
+
+func (e *markdownEncoder) end() error { return nil }
+
+// markdownReplacer escapes the characters which start inline Markdown or HTML.
+// Parentheses and exclamation marks only form links and images together with brackets, which are escaped.
+var markdownReplacer = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`,
+	`<`, `\<`, `>`, `\>`, `&`, `\&`, `|`, `\|`, `~`, `\~`,
+)
+
+// markdownURLReplacer escapes a link destination written in angle brackets.
+var markdownURLReplacer = strings.NewReplacer(`<`, `%3C`, `>`, `%3E`, "\n", `%0A`)
+
+// escapeMarkdown escapes a single line of text, so it is shown literally rather than interpreted as Markdown or HTML.
+// Besides inline markup, characters which would start a heading, list, quote or setext underline at the start
+// of the line are escaped.
+func escapeMarkdown(line string) string {
+	line = markdownReplacer.Replace(line)
+	trimmed := strings.TrimLeft(line, " \t")
+	indent := line[:len(line)-len(trimmed)]
+	switch {
+	case trimmed == "":
+		return line
+	case strings.ContainsRune("#-+=", rune(trimmed[0])):
+		return indent + `\` + trimmed
+	}
+	// Ordered list items like "1." or "1)"
+	digits := len(trimmed) - len(strings.TrimLeft(trimmed, "0123456789"))
+	if digits > 0 && digits < len(trimmed) && (trimmed[digits] == '.' || trimmed[digits] == ')') {
+		return indent + trimmed[:digits] + `\` + trimmed[digits:]
+	}
+	return line
+}
+
+// htmlEncoder writes a standalone HTML page.
+type htmlEncoder struct {
+	w     io.Writer
+	title string
+}
+
+func newHTMLEncoder(w io.Writer, title string) *htmlEncoder {
+	return &htmlEncoder{w: w, title: title}
+}
+
+var htmlTemplates = template.Must(template.New("begin").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.}}</title>
+<style>
+body { font-family: sans-serif; max-width: 48em; margin: 2em auto; padding: 0 1em; background: #f4f4f7; }
+.message { margin: 1em 0; padding: .75em 1em; border-radius: .75em; background: #fff; }
+.message.user { margin-left: 4em; background: #dceeff; }
+.message.ai { margin-right: 4em; }
+.meta { font-size: .8em; color: #666; margin-bottom: .25em; }
+.text { white-space: pre-wrap; }
+audio { display: block; margin-top: .5em; }
+</style>
+</head>
+<body>
+<h1>{{.}}</h1>
+`))
+
+func init() {
+	template.Must(htmlTemplates.New("message").Parse(`<div class="message {{.Sender}}" id="{{.ID}}">
+<div class="meta"><strong>{{.Label}}</strong> · {{.Time}}</div>
+<div class="text">{{.Message}}</div>
+{{- if .AudioFile}}
+<audio controls src="{{.AudioFile}}"></audio>
+{{- else if .AudioData}}
+<audio controls src="{{.DataURI}}"></audio>
+{{- end}}
+</div>
+`))
+}
+
+func (e *htmlEncoder) begin() error {
+	return htmlTemplates.ExecuteTemplate(e.w, "begin", e.title)
+}
+
+func (e *htmlEncoder) write(m *entry) error {
+	return htmlTemplates.ExecuteTemplate(e.w, "message", struct {
+		*entry
+		DataURI template.URL
+	}{m, template.URL(dataURI(m))})
+}
+
+func (e *htmlEncoder) end() error {
+	_, err := io.WriteString(e.w, "</body>\n</html>\n")
+	return err
+}
+
+// dataURI returns the embedded audio of the entry as a data URI.
+func dataURI(m *entry) string {
+	if m.AudioData == "" {
+		return ""
+	}
+	return "data:" + m.AudioType + ";base64," + m.AudioData
+}
@@ -0,0 +1,135 @@
+// Package export
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/client"
+)
+
+// Roles of OpenAI chat messages.
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// OpenAIMessage is a message of the OpenAI Chat Completions API.
+type OpenAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	Name    string `json:"name,omitempty"`
+}
+
+// UnmarshalJSON accepts the content as a string or as an array of content parts, whose text parts are joined.
+func (m *OpenAIMessage) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+		Name    string          `json:"name"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	m.Role, m.Name, m.Content = raw.Role, raw.Name, ""
+	if len(raw.Content) == 0 || string(raw.Content) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(raw.Content, &m.Content); err == nil {
+		return nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(raw.Content, &parts); err != nil {
+		return fmt.Errorf("invalid message content: %w", err)
+	}
+	var texts []string
+	for _, part := range parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	m.Content = strings.Join(texts, "\n")
+	return nil
+}
+
+// OpenAIMessages converts the transcript into OpenAI chat messages.
+// AI messages become assistant messages; messages of other senders are left out.
+func OpenAIMessages(t *Transcript) []OpenAIMessage {
+	messages := make([]OpenAIMessage, 0, len(t.Messages))
+	for _, message := range t.Messages {
+		var role string
+		switch message.Sender {
+		case client.SenderUser:
+			role = RoleUser
+		case client.SenderAI:
+			role = RoleAssistant
+		case SenderSystem:
+			role = RoleSystem
+		default:
+			continue
+		}
+		messages = append(messages, OpenAIMessage{Role: role, Content: message.Content})
+	}
+	return messages
+}
+
+// FromOpenAIMessages converts OpenAI chat messages into a transcript.
+// Messages of roles other than system, user and assistant, like tool results, are left out.
+func FromOpenAIMessages(messages []OpenAIMessage) *Transcript {
+	t := &Transcript{UserName: "User", AIName: "AI"}
+	for _, message := range messages {
+		var sender string
+		switch message.Role {
+		case RoleUser:
+			sender = client.SenderUser
+		case RoleAssistant:
+			sender = client.SenderAI
+		case RoleSystem, "developer":
+			sender = SenderSystem
+		default:
+			continue
+		}
+		t.Messages = append(t.Messages, TranscriptMessage{Sender: sender, Name: message.Name, Content: message.Content})
+	}
+	return t
+}
+
+// ParseOpenAIMessages parses a JSON array of OpenAI chat messages, or a request object with a "messages" field.
+func ParseOpenAIMessages(data []byte) (*Transcript, error) {
+	var messages []OpenAIMessage
+	if err := json.Unmarshal(data, &messages); err != nil {
+		var request struct {
+			Messages *[]OpenAIMessage `json:"messages"`
+		}
+		if errRequest := json.Unmarshal(data, &request); errRequest != nil || request.Messages == nil {
+			return nil, fmt.Errorf("invalid OpenAI messages: %w", err)
+		}
+		messages = *request.Messages
+	}
+	for i, message := range messages {
+		if message.Role == "" {
+			return nil, fmt.Errorf("invalid OpenAI messages: message %d has no role", i)
+		}
+	}
+	return FromOpenAIMessages(messages), nil
+}
@@ -0,0 +1,172 @@
+// Package export
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/client"
+)
+
+// SillyTavern formats dates like "March 1, 2024 12:00pm" and "2024-3-1@12h00m00s".
+const (
+	sillyTavernSendDate   = "January 2, 2006 3:04pm"
+	sillyTavernCreateDate = "2006-1-2@15h04m05s"
+)
+
+// sillyTavernHeader is the first line of a SillyTavern chat file.
+type sillyTavernHeader struct {
+	UserName      string         `json:"user_name"`
+	CharacterName string         `json:"character_name"`
+	CreateDate    string         `json:"create_date"`
+	ChatMetadata  map[string]any `json:"chat_metadata"`
+}
+
+// sillyTavernMessage is a message line of a SillyTavern chat file.
+type sillyTavernMessage struct {
+	Name     string          `json:"name"`
+	IsUser   bool            `json:"is_user"`
+	IsSystem bool            `json:"is_system"`
+	SendDate json.RawMessage `json:"send_date"`
+	Mes      *string         `json:"mes"`
+	Extra    sillyTavernData `json:"extra"`
+}
+
+// sillyTavernData keeps the Kindroid message ID and exact timestamp, which SillyTavern ignores.
+type sillyTavernData struct {
+	KindroidID        string `json:"kindroid_id,omitempty"`
+	KindroidTimestamp int64  `json:"kindroid_timestamp,omitempty"`
+}
+
+// WriteSillyTavern writes the transcript as a SillyTavern chat file (JSON Lines), which can be imported as a chat
+// of a character named like the transcript's AI.
+func WriteSillyTavern(w io.Writer, t *Transcript) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	created := t.CreatedAt
+	if created.IsZero() {
+		created = time.Now()
+	}
+	if err := enc.Encode(sillyTavernHeader{
+		UserName:      t.UserName,
+		CharacterName: t.AIName,
+		CreateDate:    created.Format(sillyTavernCreateDate),
+		ChatMetadata:  map[string]any{},
+	}); err != nil {
+		return err
+	}
+
+	for _, message := range t.Messages {
+		content := message.Content
+		line := sillyTavernMessage{
+			Name:     message.Name,
+			IsUser:   message.Sender == client.SenderUser,
+			IsSystem: message.Sender == SenderSystem,
+			Mes:      &content,
+			Extra:    sillyTavernData{KindroidID: message.ID},
+		}
+		if line.Name == "" {
+			line.Name = t.AIName
+			if line.IsUser {
+				line.Name = t.UserName
+			}
+		}
+		sendDate := ""
+		if !message.Time.IsZero() {
+			sendDate = message.Time.Format(sillyTavernSendDate)
+			line.Extra.KindroidTimestamp = message.Time.UnixMilli()
+		}
+		line.SendDate, _ = json.Marshal(sendDate)
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadSillyTavern parses a SillyTavern chat file.
+func ReadSillyTavern(r io.Reader) (*Transcript, error) {
+	t := &Transcript{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var message sillyTavernMessage
+		if err := json.Unmarshal([]byte(line), &message); err != nil {
+			return nil, fmt.Errorf("invalid SillyTavern chat line %d: %w", lineNumber, err)
+		}
+		if message.Mes == nil {
+			// The header line carries the names instead of a message
+			var header sillyTavernHeader
+			if err := json.Unmarshal([]byte(line), &header); err != nil {
+				return nil, fmt.Errorf("invalid SillyTavern chat line %d: %w", lineNumber, err)
+			}
+			t.UserName, t.AIName = header.UserName, header.CharacterName
+			t.CreatedAt, _ = time.ParseInLocation(sillyTavernCreateDate, header.CreateDate, time.Local)
+			continue
+		}
+
+		converted := TranscriptMessage{ID: message.Extra.KindroidID, Name: message.Name, Content: *message.Mes, Sender: client.SenderAI}
+		switch {
+		case message.IsSystem:
+			converted.Sender = SenderSystem
+		case message.IsUser:
+			converted.Sender = client.SenderUser
+		}
+		if message.Extra.KindroidTimestamp != 0 {
+			converted.Time = time.UnixMilli(message.Extra.KindroidTimestamp)
+		} else {
+			converted.Time = parseSillyTavernDate(message.SendDate)
+		}
+		t.Messages = append(t.Messages, converted)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if t.AIName == "" && len(t.Messages) == 0 {
+		return nil, errors.New("not a SillyTavern chat file")
+	}
+	return t, nil
+}
+
+// parseSillyTavernDate parses the send date of a message, which depending on the SillyTavern version
+// is a humanized date, an ISO 8601 date or Unix milliseconds. Zero is returned if the date is unknown.
+func parseSillyTavernDate(raw json.RawMessage) time.Time {
+	var millis int64
+	if err := json.Unmarshal(raw, &millis); err == nil {
+		return time.UnixMilli(millis)
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return time.Time{}
+	}
+	for _, layout := range []string{sillyTavernSendDate, time.RFC3339Nano, sillyTavernCreateDate} {
+		if parsed, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return parsed
+		}
+	}
+	return time.Time{}
+}
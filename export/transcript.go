@@ -0,0 +1,102 @@
+// Package export
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package export
+
+import (
+	"time"
+
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/client"
+)
+
+// SenderSystem is the sender of system messages in a Transcript, which Kindroid chats do not have.
+const SenderSystem = "system"
+
+// Transcript is a conversation in a normalized form, used to convert between Kindroid and other chat frontends.
+type Transcript struct {
+	// UserName and AIName are the display names of the participants.
+	UserName string
+	AIName   string
+	// CreatedAt is the creation time of the chat, if known.
+	CreatedAt time.Time
+	// Messages are ordered oldest first.
+	Messages []TranscriptMessage
+}
+
+// TranscriptMessage is a single message of a Transcript.
+type TranscriptMessage struct {
+	// ID is the Kindroid message ID, if known.
+	ID string
+	// Sender is client.SenderUser, client.SenderAI or SenderSystem.
+	Sender string
+	// Name is the display name of the sender, if known.
+	Name    string
+	Content string
+	// Time is the time the message was sent, if known.
+	Time time.Time
+}
+
+// TranscriptOptions configures NewTranscript.
+type TranscriptOptions struct {
+	// UserName and AIName default to "User" and "AI".
+	UserName string
+	AIName   string
+	// SkipDecryptionFailed drops messages which could not be decrypted instead of keeping the placeholder text.
+	SkipDecryptionFailed bool
+}
+
+// NewTranscript converts chat messages, ordered oldest first, into a Transcript.
+func NewTranscript(messages []*client.ChatMessage, opts TranscriptOptions) *Transcript {
+	t := &Transcript{UserName: opts.UserName, AIName: opts.AIName}
+	if t.UserName == "" {
+		t.UserName = "User"
+	}
+	if t.AIName == "" {
+		t.AIName = "AI"
+	}
+	for _, message := range messages {
+		if opts.SkipDecryptionFailed && message.Message == client.DecryptionFailed {
+			continue
+		}
+		name := t.AIName
+		if message.Sender == client.SenderUser {
+			name = t.UserName
+		}
+		t.Messages = append(t.Messages, TranscriptMessage{
+			ID:      message.ID,
+			Sender:  message.Sender,
+			Name:    name,
+			Content: message.Message,
+			Time:    message.GetTime(),
+		})
+	}
+	if len(t.Messages) > 0 {
+		t.CreatedAt = t.Messages[0].Time
+	}
+	return t
+}
+
+// ChatMessages converts the transcript back into chat messages. Messages without a time have a zero Timestamp.
+func (t *Transcript) ChatMessages() []*client.ChatMessage {
+	messages := make([]*client.ChatMessage, len(t.Messages))
+	for i, message := range t.Messages {
+		messages[i] = &client.ChatMessage{ID: message.ID, Sender: message.Sender, Message: message.Content}
+		if !message.Time.IsZero() {
+			messages[i].Timestamp = message.Time.UnixMilli()
+		}
+	}
+	return messages
+}
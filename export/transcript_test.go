@@ -0,0 +1,141 @@
+// Package export
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var conversionMessages = []*client.ChatMessage{
+	{ID: "m1", Sender: client.SenderUser, Message: "Quotes \" and backslashes \\, <tags> & emoji 🎉", Timestamp: baseTimestamp},
+	{ID: "m2", Sender: client.SenderAI, Message: "Line one\nLine two\ttabbed", Timestamp: baseTimestamp + 1_500},
+	{ID: "m3", Sender: client.SenderUser, Message: "", Timestamp: baseTimestamp + 60_000},
+	{ID: "m4", Sender: client.SenderAI, Message: client.DecryptionFailed, Timestamp: baseTimestamp + 120_000},
+}
+
+func TestSillyTavernRoundTrip(t *testing.T) {
+	var out bytes.Buffer
+	transcript := NewTranscript(conversionMessages, TranscriptOptions{UserName: "Sam", AIName: "Aria"})
+	require.NoError(t, WriteSillyTavern(&out, transcript))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 5)
+	var header map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &header))
+	assert.Equal(t, "Sam", header["user_name"])
+	assert.Equal(t, "Aria", header["character_name"])
+	assert.Contains(t, lines[1], `<tags> & emoji 🎉`, "HTML characters are not escaped")
+	assert.Contains(t, lines[1], `"name":"Sam","is_user":true`)
+	assert.Contains(t, lines[2], `"name":"Aria","is_user":false`)
+
+	parsed, err := ReadSillyTavern(&out)
+	require.NoError(t, err)
+	assert.Equal(t, "Sam", parsed.UserName)
+	assert.Equal(t, "Aria", parsed.AIName)
+	assert.Equal(t, conversionMessages, parsed.ChatMessages())
+
+	// Undecryptable messages can be left out
+	transcript = NewTranscript(conversionMessages, TranscriptOptions{SkipDecryptionFailed: true})
+	assert.Len(t, transcript.Messages, 3)
+	assert.Equal(t, "User", transcript.UserName)
+	assert.Equal(t, "AI", transcript.Messages[1].Name)
+}
+
+func TestReadSillyTavern(t *testing.T) {
+	// Written by SillyTavern itself, without Kindroid metadata
+	chat := `{"user_name":"You","character_name":"Seraphina","create_date":"2024-3-1@12h00m00s","chat_metadata":{"note_prompt":""}}
+{"name":"Seraphina","is_user":false,"is_system":false,"send_date":"March 1, 2024 12:00pm","mes":"*She smiles.* Welcome!","extra":{}}
+
+{"name":"You","is_user":true,"is_system":false,"send_date":"2024-03-01T12:05:00.000Z","mes":"Thanks!","extra":{}}
+{"name":"Narrator","is_user":false,"is_system":true,"send_date":1709295000000,"mes":"Time passes.","extra":{}}
+{"name":"Seraphina","is_user":false,"is_system":false,"send_date":"someday","mes":"","extra":{}}
+`
+	transcript, err := ReadSillyTavern(strings.NewReader(chat))
+	require.NoError(t, err)
+	assert.Equal(t, "You", transcript.UserName)
+	assert.Equal(t, "Seraphina", transcript.AIName)
+	assert.Equal(t, time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local), transcript.CreatedAt)
+
+	require.Len(t, transcript.Messages, 4)
+	assert.Equal(t, TranscriptMessage{Sender: client.SenderAI, Name: "Seraphina", Content: "*She smiles.* Welcome!",
+		Time: time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)}, transcript.Messages[0])
+	assert.Equal(t, client.SenderUser, transcript.Messages[1].Sender)
+	assert.True(t, time.Date(2024, 3, 1, 12, 5, 0, 0, time.UTC).Equal(transcript.Messages[1].Time))
+	assert.Equal(t, SenderSystem, transcript.Messages[2].Sender)
+	assert.Equal(t, int64(1709295000000), transcript.Messages[2].Time.UnixMilli())
+	assert.True(t, transcript.Messages[3].Time.IsZero())
+	assert.Equal(t, "", transcript.Messages[3].Content)
+
+	_, err = ReadSillyTavern(strings.NewReader("{not json}\n"))
+	assert.ErrorContains(t, err, "line 1")
+	_, err = ReadSillyTavern(strings.NewReader(""))
+	assert.Error(t, err)
+}
+
+func TestOpenAIRoundTrip(t *testing.T) {
+	messages := OpenAIMessages(NewTranscript(conversionMessages, TranscriptOptions{}))
+	assert.Equal(t, []OpenAIMessage{
+		{Role: RoleUser, Content: conversionMessages[0].Message},
+		{Role: RoleAssistant, Content: "Line one\nLine two\ttabbed"},
+		{Role: RoleUser, Content: ""},
+		{Role: RoleAssistant, Content: client.DecryptionFailed},
+	}, messages)
+
+	data, err := json.Marshal(messages)
+	require.NoError(t, err)
+	transcript, err := ParseOpenAIMessages(data)
+	require.NoError(t, err)
+	converted := transcript.ChatMessages()
+	require.Len(t, converted, len(conversionMessages))
+	for i, message := range converted {
+		assert.Equal(t, conversionMessages[i].Sender, message.Sender)
+		assert.Equal(t, conversionMessages[i].Message, message.Message)
+		assert.Zero(t, message.Timestamp, "OpenAI messages have no timestamps")
+	}
+
+	messages = OpenAIMessages(NewTranscript(conversionMessages, TranscriptOptions{SkipDecryptionFailed: true}))
+	assert.Len(t, messages, 3)
+}
+
+func TestParseOpenAIMessages(t *testing.T) {
+	transcript, err := ParseOpenAIMessages([]byte(`{"model": "gpt-4o", "messages": [
+		{"role": "developer", "content": "Be nice."},
+		{"role": "user", "name": "sam", "content": [{"type": "text", "text": "Look at this"}, {"type": "image_url", "image_url": {"url": "https://example.com/cat.png"}}, {"type": "text", "text": "cat"}]},
+		{"role": "assistant", "content": null},
+		{"role": "tool", "content": "42"}
+	]}`))
+	require.NoError(t, err)
+	assert.Equal(t, []TranscriptMessage{
+		{Sender: SenderSystem, Content: "Be nice."},
+		{Sender: client.SenderUser, Name: "sam", Content: "Look at this\ncat"},
+		{Sender: client.SenderAI, Content: ""},
+	}, transcript.Messages)
+
+	for _, invalid := range []string{`{"messages": "none"}`, `{}`, `[{"content": "no role"}]`, `[{"role": "user", "content": 42}]`} {
+		_, err = ParseOpenAIMessages([]byte(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
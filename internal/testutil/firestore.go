@@ -0,0 +1,445 @@
+// Package testutil
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testutil
+
+import (
+	"cmp"
+	"context"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pb "cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/Luzifer/go-openssl/v4"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Firestore is an in-process stand-in for the Firestore emulator.
+// It implements the subset of the Firestore gRPC API used by the client: document lookups,
+// structured queries (filters, ordering, cursors and limits) and snapshot listeners.
+type Firestore struct {
+	pb.UnimplementedFirestoreServer
+
+	// Addr is the host:port to configure as Firestore emulator host.
+	Addr string
+
+	project    string
+	mu         sync.Mutex
+	docs       map[string]*pb.Document
+	clock      time.Time
+	listeners  map[chan struct{}]bool
+	breakWatch chan struct{}
+	authTokens []string
+}
+
+// Message describes a chat message document. Message and Audio are stored encrypted.
+type Message struct {
+	ID        string
+	Message   string
+	Sender    string
+	Timestamp int64
+	Audio     string
+}
+
+// NewFirestore starts a fake Firestore serving documents of the given project, stopped at the end of the test.
+func NewFirestore(t *testing.T, project string) *Firestore {
+	t.Helper()
+	f := &Firestore{
+		project:    project,
+		docs:       make(map[string]*pb.Document),
+		clock:      time.Unix(1700000000, 0),
+		listeners:  make(map[chan struct{}]bool),
+		breakWatch: make(chan struct{}),
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := grpc.NewServer(grpc.UnaryInterceptor(f.recordAuth), grpc.StreamInterceptor(f.recordStreamAuth))
+	pb.RegisterFirestoreServer(server, f)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	f.Addr = listener.Addr().String()
+	return f
+}
+
+// Encrypt encrypts a value the same way the Kindroid app stores it in Firestore.
+func Encrypt(t *testing.T, userID, plaintext string) string {
+	t.Helper()
+	encrypted, err := openssl.New().EncryptBytes(userID, []byte(plaintext), openssl.BytesToKeyMD5)
+	if err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+	return "!enc:" + string(encrypted)
+}
+
+func (f *Firestore) docName(path string) string {
+	return "projects/" + f.project + "/databases/(default)/documents/" + path
+}
+
+// PutMessage stores an encrypted chat message and notifies snapshot listeners.
+func (f *Firestore) PutMessage(t *testing.T, userID, aiID string, msg Message) {
+	t.Helper()
+	fields := map[string]*pb.Value{
+		"message":   {ValueType: &pb.Value_StringValue{StringValue: Encrypt(t, userID, msg.Message)}},
+		"sender":    {ValueType: &pb.Value_StringValue{StringValue: msg.Sender}},
+		"timestamp": {ValueType: &pb.Value_IntegerValue{IntegerValue: msg.Timestamp}},
+	}
+	if msg.Audio != "" {
+		fields["audio"] = &pb.Value{ValueType: &pb.Value_StringValue{StringValue: Encrypt(t, userID, msg.Audio)}}
+	}
+	f.PutFields("Users/"+userID+"/AIs/"+aiID+"/ChatMessages/"+msg.ID, fields)
+}
+
+// PutFields stores a document with raw fields and notifies snapshot listeners.
+func (f *Firestore) PutFields(path string, fields map[string]*pb.Value) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.clock = f.clock.Add(time.Millisecond)
+	name := f.docName(path)
+	doc := &pb.Document{Name: name, Fields: fields, UpdateTime: timestamppb.New(f.clock)}
+	if previous, ok := f.docs[name]; ok {
+		doc.CreateTime = previous.CreateTime
+	} else {
+		doc.CreateTime = doc.UpdateTime
+	}
+	f.docs[name] = doc
+	f.notify()
+}
+
+// DeleteDoc removes a document and notifies snapshot listeners.
+func (f *Firestore) DeleteDoc(path string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.docs, f.docName(path))
+	f.notify()
+}
+
+func (f *Firestore) notify() {
+	for listener := range f.listeners {
+		select {
+		case listener <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// InterruptWatches aborts all active snapshot listeners with a permanent error.
+func (f *Firestore) InterruptWatches() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	close(f.breakWatch)
+	f.breakWatch = make(chan struct{})
+}
+
+// Tokens returns the bearer tokens received so far.
+func (f *Firestore) Tokens() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.authTokens)
+}
+
+func (f *Firestore) record(md metadata.MD) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, value := range md.Get("authorization") {
+		f.authTokens = append(f.authTokens, strings.TrimPrefix(value, "Bearer "))
+	}
+}
+
+func (f *Firestore) recordAuth(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	f.record(md)
+	return handler(ctx, req)
+}
+
+func (f *Firestore) recordStreamAuth(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	md, _ := metadata.FromIncomingContext(ss.Context())
+	f.record(md)
+	return handler(srv, ss)
+}
+
+func (f *Firestore) BatchGetDocuments(req *pb.BatchGetDocumentsRequest, stream pb.Firestore_BatchGetDocumentsServer) error {
+	f.mu.Lock()
+	readTime := timestamppb.New(f.clock)
+	var responses []*pb.BatchGetDocumentsResponse
+	for _, name := range req.Documents {
+		if doc, ok := f.docs[name]; ok {
+			responses = append(responses, &pb.BatchGetDocumentsResponse{Result: &pb.BatchGetDocumentsResponse_Found{Found: doc}, ReadTime: readTime})
+		} else {
+			responses = append(responses, &pb.BatchGetDocumentsResponse{Result: &pb.BatchGetDocumentsResponse_Missing{Missing: name}, ReadTime: readTime})
+		}
+	}
+	f.mu.Unlock()
+
+	for _, resp := range responses {
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Firestore) RunQuery(req *pb.RunQueryRequest, stream pb.Firestore_RunQueryServer) error {
+	f.mu.Lock()
+	docs := f.query(req.Parent, req.GetStructuredQuery())
+	readTime := timestamppb.New(f.clock)
+	f.mu.Unlock()
+
+	if len(docs) == 0 {
+		return stream.Send(&pb.RunQueryResponse{ReadTime: readTime})
+	}
+	for _, doc := range docs {
+		if err := stream.Send(&pb.RunQueryResponse{Document: doc, ReadTime: readTime}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Firestore) Listen(stream pb.Firestore_ListenServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	target := req.GetAddTarget()
+	if target == nil || (target.GetQuery() == nil && target.GetDocuments() == nil) {
+		return status.Error(codes.InvalidArgument, "only query and document targets are supported")
+	}
+	// targetDocs evaluates the target against the stored documents. The caller must hold f.mu.
+	targetDocs := func() []*pb.Document {
+		if query := target.GetQuery(); query != nil {
+			return f.query(query.Parent, query.GetStructuredQuery())
+		}
+		var docs []*pb.Document
+		for _, name := range target.GetDocuments().Documents {
+			if doc, ok := f.docs[name]; ok {
+				docs = append(docs, doc)
+			}
+		}
+		return docs
+	}
+	targetIDs := []int32{target.TargetId}
+
+	updates := make(chan struct{}, 1)
+	f.mu.Lock()
+	f.listeners[updates] = true
+	breakWatch := f.breakWatch
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		delete(f.listeners, updates)
+		f.mu.Unlock()
+	}()
+
+	if err = stream.Send(&pb.ListenResponse{ResponseType: &pb.ListenResponse_TargetChange{TargetChange: &pb.TargetChange{
+		TargetChangeType: pb.TargetChange_ADD, TargetIds: targetIDs,
+	}}}); err != nil {
+		return err
+	}
+
+	sent := make(map[string]*pb.Document)
+	current := false
+	for {
+		f.mu.Lock()
+		docs := targetDocs()
+		readTime := timestamppb.New(f.clock)
+		f.mu.Unlock()
+
+		var responses []*pb.ListenResponse
+		matching := make(map[string]bool)
+		for _, doc := range docs {
+			matching[doc.Name] = true
+			if previous, ok := sent[doc.Name]; ok && previous.UpdateTime.AsTime().Equal(doc.UpdateTime.AsTime()) {
+				continue
+			}
+			sent[doc.Name] = doc
+			responses = append(responses, &pb.ListenResponse{ResponseType: &pb.ListenResponse_DocumentChange{DocumentChange: &pb.DocumentChange{
+				Document: doc, TargetIds: targetIDs,
+			}}})
+		}
+		for name := range sent {
+			if !matching[name] {
+				delete(sent, name)
+				responses = append(responses, &pb.ListenResponse{ResponseType: &pb.ListenResponse_DocumentDelete{DocumentDelete: &pb.DocumentDelete{
+					Document: name, RemovedTargetIds: targetIDs, ReadTime: readTime,
+				}}})
+			}
+		}
+		if !current {
+			current = true
+			responses = append(responses, &pb.ListenResponse{ResponseType: &pb.ListenResponse_TargetChange{TargetChange: &pb.TargetChange{
+				TargetChangeType: pb.TargetChange_CURRENT, TargetIds: targetIDs,
+			}}})
+		}
+		responses = append(responses, &pb.ListenResponse{ResponseType: &pb.ListenResponse_TargetChange{TargetChange: &pb.TargetChange{
+			TargetChangeType: pb.TargetChange_NO_CHANGE, ReadTime: readTime, ResumeToken: []byte(readTime.AsTime().String()),
+		}}})
+
+		for _, resp := range responses {
+			if err = stream.Send(resp); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-updates:
+		case <-breakWatch:
+			return status.Error(codes.PermissionDenied, "watch interrupted by test")
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// query evaluates a structured query against the stored documents. The caller must hold f.mu.
+func (f *Firestore) query(parent string, q *pb.StructuredQuery) []*pb.Document {
+	var docs []*pb.Document
+	for _, from := range q.From {
+		prefix := parent + "/" + from.CollectionId + "/"
+		for name, doc := range f.docs {
+			if strings.HasPrefix(name, prefix) && !strings.Contains(strings.TrimPrefix(name, prefix), "/") && matchesFilter(doc, q.Where) {
+				docs = append(docs, doc)
+			}
+		}
+	}
+
+	orders := q.OrderBy
+	if len(orders) == 0 || orders[len(orders)-1].Field.FieldPath != "__name__" {
+		orders = append(slices.Clone(orders), &pb.StructuredQuery_Order{Field: &pb.StructuredQuery_FieldReference{FieldPath: "__name__"}, Direction: pb.StructuredQuery_ASCENDING})
+	}
+	slices.SortFunc(docs, func(a, b *pb.Document) int {
+		return compareByOrder(orderValues(a, orders), orderValues(b, orders), orders)
+	})
+
+	if q.StartAt != nil {
+		docs = slices.DeleteFunc(docs, func(doc *pb.Document) bool {
+			c := compareByOrder(orderValues(doc, orders)[:len(q.StartAt.Values)], q.StartAt.Values, orders)
+			return c < 0 || (c == 0 && !q.StartAt.Before)
+		})
+	}
+	if q.Limit != nil && int(q.Limit.Value) < len(docs) {
+		docs = docs[:q.Limit.Value]
+	}
+	return docs
+}
+
+func orderValues(doc *pb.Document, orders []*pb.StructuredQuery_Order) []*pb.Value {
+	values := make([]*pb.Value, len(orders))
+	for i, order := range orders {
+		if order.Field.FieldPath == "__name__" {
+			values[i] = &pb.Value{ValueType: &pb.Value_ReferenceValue{ReferenceValue: doc.Name}}
+		} else {
+			values[i] = doc.Fields[order.Field.FieldPath]
+		}
+	}
+	return values
+}
+
+func compareByOrder(a, b []*pb.Value, orders []*pb.StructuredQuery_Order) int {
+	for i := range min(len(a), len(b)) {
+		c := compareValues(a[i], b[i])
+		if orders[i].Direction == pb.StructuredQuery_DESCENDING {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareValues compares the value types used by chat messages. Missing values sort first.
+func compareValues(a, b *pb.Value) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	// Like Firestore, integers and doubles are compared by their numeric value
+	if an, ok := numericValue(a); ok {
+		bn, _ := numericValue(b)
+		return cmp.Compare(an, bn)
+	}
+	switch av := a.ValueType.(type) {
+	case *pb.Value_StringValue:
+		return cmp.Compare(av.StringValue, b.GetStringValue())
+	case *pb.Value_ReferenceValue:
+		return cmp.Compare(av.ReferenceValue, b.GetReferenceValue())
+	}
+	return 0
+}
+
+func numericValue(v *pb.Value) (float64, bool) {
+	switch value := v.ValueType.(type) {
+	case *pb.Value_IntegerValue:
+		return float64(value.IntegerValue), true
+	case *pb.Value_DoubleValue:
+		return value.DoubleValue, true
+	}
+	return 0, false
+}
+
+func matchesFilter(doc *pb.Document, filter *pb.StructuredQuery_Filter) bool {
+	if filter == nil {
+		return true
+	}
+	switch ft := filter.FilterType.(type) {
+	case *pb.StructuredQuery_Filter_CompositeFilter:
+		for _, sub := range ft.CompositeFilter.Filters {
+			if !matchesFilter(doc, sub) {
+				return false
+			}
+		}
+		return true
+	case *pb.StructuredQuery_Filter_FieldFilter:
+		value, ok := doc.Fields[ft.FieldFilter.Field.FieldPath]
+		if !ok {
+			return false
+		}
+		c := compareValues(value, ft.FieldFilter.Value)
+		switch ft.FieldFilter.Op {
+		case pb.StructuredQuery_FieldFilter_LESS_THAN:
+			return c < 0
+		case pb.StructuredQuery_FieldFilter_LESS_THAN_OR_EQUAL:
+			return c <= 0
+		case pb.StructuredQuery_FieldFilter_GREATER_THAN:
+			return c > 0
+		case pb.StructuredQuery_FieldFilter_GREATER_THAN_OR_EQUAL:
+			return c >= 0
+		case pb.StructuredQuery_FieldFilter_EQUAL:
+			return c == 0
+		case pb.StructuredQuery_FieldFilter_NOT_EQUAL:
+			return c != 0
+		}
+	}
+	return false
+}
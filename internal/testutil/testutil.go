@@ -0,0 +1,49 @@
+// Package testutil
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package testutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWT returns an unsigned-looking JWT carrying the given user ID, as issued by Firebase.
+func JWT(userID string) string {
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": userID}).SignedString([]byte("test"))
+	return token
+}
+
+// NewSecureTokenServer fakes the Firebase secure token endpoint, issuing idToken in exchange for refreshToken.
+// Other refresh tokens are rejected. The returned counter reports the number of requests.
+func NewSecureTokenServer(t *testing.T, refreshToken, idToken string) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.FormValue("grant_type") != "refresh_token" || r.FormValue("refresh_token") != refreshToken {
+			http.Error(w, `{"error":{"message":"INVALID_REFRESH_TOKEN"}}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "expires_in": "3600"})
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
@@ -0,0 +1,225 @@
+// Package server
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/client"
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/export"
+)
+
+// maxRequestSize limits the size of request bodies.
+const maxRequestSize = 1 << 20
+
+// chatCompletionRequest contains the supported fields of a chat completion request; all others are ignored.
+// Kindroid keeps the chat history itself, so only the last message of the request is used.
+type chatCompletionRequest struct {
+	Model    string                 `json:"model"`
+	Messages []export.OpenAIMessage `json:"messages"`
+	Stream   bool                   `json:"stream"`
+}
+
+type chatCompletion struct {
+	ID      string                 `json:"id"`
+	Object  string                 `json:"object"`
+	Created int64                  `json:"created"`
+	Model   string                 `json:"model"`
+	Choices []chatCompletionChoice `json:"choices"`
+}
+
+type chatCompletionChoice struct {
+	Index        int                   `json:"index"`
+	Message      *export.OpenAIMessage `json:"message,omitempty"`
+	Delta        *chatCompletionDelta  `json:"delta,omitempty"`
+	FinishReason *string               `json:"finish_reason"`
+}
+
+type chatCompletionDelta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+var finishReasonStop = "stop"
+
+func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	var req chatCompletionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid_json", "Invalid request body: "+err.Error())
+		return
+	}
+	aiID, ok := s.resolveModel(req.Model)
+	if !ok {
+		writeModelNotFound(w, req.Model)
+		return
+	}
+
+	if greeting, ok := s.breakCommand(req.Messages); ok {
+		if greeting == "" {
+			writeError(w, http.StatusBadRequest, "invalid_request_error", "missing_greeting",
+				fmt.Sprintf("The command %s requires a greeting, e.g. '%s Hello again!'.", s.cfg.BreakCommand, s.cfg.BreakCommand))
+			return
+		}
+		if err := s.client.ChatBreakAI(r.Context(), aiID, greeting); err != nil {
+			s.writeUpstreamError(w, r, err)
+			return
+		}
+		s.writeCompletion(w, aiID, req.Stream, greeting)
+		return
+	}
+
+	message := lastUserMessage(req.Messages)
+	if message == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "missing_user_message", "The request contains no user message.")
+		return
+	}
+	options := client.SendMessageOptions{AIID: aiID, Message: message}
+	if req.Stream {
+		s.streamCompletion(w, r, options)
+		return
+	}
+	result, err := s.client.SendMessageWithResult(r.Context(), options)
+	if err != nil {
+		s.writeUpstreamError(w, r, err)
+		return
+	}
+	s.writeCompletion(w, aiID, false, result.Text)
+}
+
+// breakCommand reports whether the last message of the request is the break command, and returns its greeting.
+func (s *Server) breakCommand(messages []export.OpenAIMessage) (string, bool) {
+	if len(messages) == 0 {
+		return "", false
+	}
+	last := messages[len(messages)-1]
+	if last.Role != export.RoleUser && last.Role != export.RoleSystem {
+		return "", false
+	}
+	content := strings.TrimSpace(last.Content)
+	rest, ok := strings.CutPrefix(content, s.cfg.BreakCommand)
+	if !ok || (rest != "" && rest[0] != ' ' && rest[0] != '\n' && rest[0] != '\t') {
+		return "", false
+	}
+	return strings.TrimSpace(rest), true
+}
+
+// lastUserMessage returns the content of the last user message, or "" if there is none.
+func lastUserMessage(messages []export.OpenAIMessage) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == export.RoleUser {
+			return strings.TrimSpace(messages[i].Content)
+		}
+	}
+	return ""
+}
+
+// writeCompletion writes a complete reply, either as a single response or as a stream with a single delta.
+func (s *Server) writeCompletion(w http.ResponseWriter, aiID string, stream bool, text string) {
+	if stream {
+		sse := newEventWriter(w, newID("chatcmpl-"), aiID)
+		sse.delta(chatCompletionDelta{Role: export.RoleAssistant})
+		sse.delta(chatCompletionDelta{Content: text})
+		sse.finish()
+		return
+	}
+	writeJSON(w, http.StatusOK, chatCompletion{
+		ID:      newID("chatcmpl-"),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   aiID,
+		Choices: []chatCompletionChoice{{
+			Message:      &export.OpenAIMessage{Role: export.RoleAssistant, Content: text},
+			FinishReason: &finishReasonStop,
+		}},
+	})
+}
+
+// streamCompletion forwards the reply as it is generated.
+// Errors before the first chunk are reported as error responses; later errors end the stream with an error event.
+func (s *Server) streamCompletion(w http.ResponseWriter, r *http.Request, options client.SendMessageOptions) {
+	stream, err := s.client.SendMessageStream(r.Context(), options)
+	if err != nil {
+		s.writeUpstreamError(w, r, err)
+		return
+	}
+	defer stream.Close()
+
+	sse := newEventWriter(w, newID("chatcmpl-"), options.AIID)
+	sse.delta(chatCompletionDelta{Role: export.RoleAssistant})
+	for stream.Next() {
+		if chunk := stream.Chunk(); chunk != "" {
+			sse.delta(chatCompletionDelta{Content: chunk})
+		}
+	}
+	if err = stream.Err(); err != nil {
+		if r.Context().Err() == nil {
+			s.cfg.Logger.Warn("kindroid stream failed", "error", err)
+		}
+		_, apiErr := upstreamError(err)
+		sse.event(map[string]any{"error": apiErr})
+		return
+	}
+	sse.finish()
+}
+
+// eventWriter writes chat completion chunks as Server-Sent Events.
+type eventWriter struct {
+	w       http.ResponseWriter
+	id      string
+	model   string
+	created int64
+}
+
+func newEventWriter(w http.ResponseWriter, id, model string) *eventWriter {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	return &eventWriter{w: w, id: id, model: model, created: time.Now().Unix()}
+}
+
+func (e *eventWriter) chunk(choice chatCompletionChoice) {
+	e.event(chatCompletion{ID: e.id, Object: "chat.completion.chunk", Created: e.created, Model: e.model,
+		Choices: []chatCompletionChoice{choice}})
+}
+
+func (e *eventWriter) delta(delta chatCompletionDelta) {
+	e.chunk(chatCompletionChoice{Delta: &delta})
+}
+
+// finish writes the final chunk and the terminating [DONE] event.
+func (e *eventWriter) finish() {
+	e.chunk(chatCompletionChoice{Delta: &chatCompletionDelta{}, FinishReason: &finishReasonStop})
+	e.write("[DONE]")
+}
+
+func (e *eventWriter) event(v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	e.write(string(data))
+}
+
+func (e *eventWriter) write(data string) {
+	fmt.Fprintf(e.w, "data: %s\n\n", data)
+	http.NewResponseController(e.w).Flush()
+}
@@ -0,0 +1,91 @@
+// Package server
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/client"
+)
+
+// apiError is the error object of an OpenAI error response.
+type apiError struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    *string `json:"code"`
+}
+
+func newAPIError(errType, code, message string) apiError {
+	e := apiError{Message: message, Type: errType}
+	if code != "" {
+		e.Code = &code
+	}
+	return e
+}
+
+func writeError(w http.ResponseWriter, status int, errType, code, message string) {
+	writeJSON(w, status, map[string]any{"error": newAPIError(errType, code, message)})
+}
+
+// writeParamError writes an error response for an invalid request parameter.
+func writeParamError(w http.ResponseWriter, param, code, message string) {
+	apiErr := newAPIError("invalid_request_error", code, message)
+	apiErr.Param = &param
+	writeJSON(w, http.StatusBadRequest, map[string]any{"error": apiErr})
+}
+
+func writeModelNotFound(w http.ResponseWriter, model string) {
+	writeError(w, http.StatusNotFound, "invalid_request_error", "model_not_found",
+		fmt.Sprintf("The model '%s' does not exist.", model))
+}
+
+// upstreamError maps an error of the Kindroid client to an OpenAI error response.
+// Failures of the proxy's own credentials are reported as gateway errors, since the caller cannot fix them.
+func upstreamError(err error) (int, apiError) {
+	var apiErr *client.APIError
+	switch {
+	case errors.Is(err, client.ErrRateLimited):
+		return http.StatusTooManyRequests, newAPIError("rate_limit_error", "rate_limit_exceeded", "Rate limited by Kindroid. Please retry later.")
+	case errors.Is(err, client.ErrSubscriptionRequired):
+		return http.StatusPaymentRequired, newAPIError("insufficient_quota", "subscription_required", "The Kindroid account requires a subscription for this request.")
+	case errors.Is(err, client.ErrJWTRequired):
+		return http.StatusNotImplemented, newAPIError("server_error", "jwt_required", "This request requires the proxy to authenticate to Kindroid with a JWT, e.g. using a refresh token.")
+	case errors.Is(err, client.ErrUnauthorized):
+		return http.StatusBadGateway, newAPIError("server_error", "upstream_unauthorized", "Kindroid rejected the proxy's credentials.")
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, client.ErrAudioNotReady):
+		return http.StatusGatewayTimeout, newAPIError("server_error", "upstream_timeout", "Kindroid did not respond in time.")
+	case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound:
+		return http.StatusNotFound, newAPIError("invalid_request_error", "not_found", "Not found on Kindroid.")
+	case errors.As(err, &apiErr) && apiErr.StatusCode >= 400 && apiErr.StatusCode < 500:
+		return http.StatusBadRequest, newAPIError("invalid_request_error", "upstream_rejected", "Kindroid rejected the request: "+apiErr.Body)
+	default:
+		return http.StatusBadGateway, newAPIError("server_error", "upstream_error", "The request to Kindroid failed.")
+	}
+}
+
+// writeUpstreamError logs a failed Kindroid request and writes the corresponding error response.
+func (s *Server) writeUpstreamError(w http.ResponseWriter, r *http.Request, err error) {
+	status, apiErr := upstreamError(err)
+	s.cfg.Logger.LogAttrs(r.Context(), slog.LevelWarn, "kindroid request failed",
+		slog.String("path", r.URL.Path), slog.Int("status", status), slog.Any("error", err))
+	writeJSON(w, status, map[string]any{"error": apiErr})
+}
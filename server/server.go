@@ -0,0 +1,151 @@
+// Package server
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/client"
+)
+
+// DefaultBreakCommand is the command which starts a new chat if Config.BreakCommand is not set.
+const DefaultBreakCommand = "/break"
+
+// Config configures a Server.
+type Config struct {
+	// AIIDs are the Kindroid AI IDs exposed as models. If empty, the client's KindroidID is used.
+	AIIDs []string
+	// BreakCommand starts a new chat: if the last message of a request is the command followed by a greeting,
+	// e.g. "/break Hello again!", ChatBreak is called with the greeting, which is returned as the reply.
+	// The command may be sent with the user or the system role. Defaults to DefaultBreakCommand.
+	BreakCommand string
+	// APIKey, if set, must be sent by clients as Bearer token.
+	APIKey string
+	// Logger receives request errors. Defaults to the client's logger or slog.Default.
+	Logger *slog.Logger
+}
+
+// Server serves a subset of the OpenAI API backed by Kindroid AIs, so tools speaking the OpenAI protocol
+// can chat with them. Each AI is exposed as a model named after its AI ID.
+type Server struct {
+	client *client.KindroidAI
+	speech speechBackend
+	cfg    Config
+	mux    *http.ServeMux
+	// created is reported as the creation time of the models.
+	created int64
+}
+
+// New creates a Server forwarding requests with the given client.
+func New(k *client.KindroidAI, cfg Config) (*Server, error) {
+	if len(cfg.AIIDs) == 0 {
+		if k.KindroidID == "" {
+			return nil, errors.New("no AI IDs configured")
+		}
+		cfg.AIIDs = []string{k.KindroidID}
+	}
+	if cfg.BreakCommand == "" {
+		cfg.BreakCommand = DefaultBreakCommand
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = k.Logger
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+
+	s := &Server{client: k, speech: k, cfg: cfg, mux: http.NewServeMux(), created: time.Now().Unix()}
+	s.mux.HandleFunc("GET /v1/models", s.handleModels)
+	s.mux.HandleFunc("GET /v1/models/{model}", s.handleModel)
+	s.mux.HandleFunc("POST /v1/chat/completions", s.handleChatCompletions)
+	s.mux.HandleFunc("POST /v1/audio/speech", s.handleSpeech)
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "invalid_request_error", "unknown_url", "Unknown request URL: "+r.Method+" "+r.URL.Path)
+	})
+	return s, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.cfg.APIKey != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.APIKey)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid_request_error", "invalid_api_key", "Invalid API key.")
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// model is an entry of the model list.
+type model struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+func (s *Server) model(id string) model {
+	return model{ID: id, Object: "model", Created: s.created, OwnedBy: "kindroid"}
+}
+
+func (s *Server) handleModels(w http.ResponseWriter, _ *http.Request) {
+	models := make([]model, len(s.cfg.AIIDs))
+	for i, id := range s.cfg.AIIDs {
+		models[i] = s.model(id)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"object": "list", "data": models})
+}
+
+func (s *Server) handleModel(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("model")
+	if !slices.Contains(s.cfg.AIIDs, id) {
+		writeModelNotFound(w, id)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.model(id))
+}
+
+// resolveModel returns the AI ID for the requested model. An empty model selects the first AI.
+func (s *Server) resolveModel(name string) (string, bool) {
+	if name == "" {
+		return s.cfg.AIIDs[0], true
+	}
+	return name, slices.Contains(s.cfg.AIIDs, name)
+}
+
+// newID returns a random identifier with the given prefix, like OpenAI's "chatcmpl-..." IDs.
+func newID(prefix string) string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return prefix + hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
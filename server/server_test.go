@@ -0,0 +1,339 @@
+// Package server
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testAIID  = "test_ai_id"
+	otherAIID = "other_ai_id"
+)
+
+// fakeKindroid records the requests to a fake KindroidAI API.
+type fakeKindroid struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []map[string]any
+	paths    []string
+}
+
+func newFakeKindroid(t *testing.T) *fakeKindroid {
+	api := &fakeKindroid{}
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		api.mu.Lock()
+		api.requests = append(api.requests, body)
+		api.paths = append(api.paths, r.URL.Path)
+		api.mu.Unlock()
+
+		switch r.URL.Path {
+		case "/send-message":
+			switch body["message"] {
+			case "slow down":
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+				return
+			case "forbidden":
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+			reply := fmt.Sprintf("You said: %s", body["message"])
+			if body["stream"] == true {
+				w.Header().Set("Content-Type", "text/event-stream")
+				for _, word := range strings.SplitAfter(reply, " ") {
+					fmt.Fprintf(w, "data: %s\n\n", word)
+					w.(http.Flusher).Flush()
+				}
+				return
+			}
+			fmt.Fprintf(w, `{"reply": %q, "message_id": "reply_1"}`, reply)
+		case "/chat-break":
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(api.Close)
+	return api
+}
+
+func (api *fakeKindroid) lastRequest() (string, map[string]any) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if len(api.requests) == 0 {
+		return "", nil
+	}
+	return api.paths[len(api.paths)-1], api.requests[len(api.requests)-1]
+}
+
+func newTestProxy(t *testing.T, cfg Config) (*fakeKindroid, *httptest.Server) {
+	api := newFakeKindroid(t)
+	k, err := client.New("test_api_key", client.WithKindroidID(testAIID), client.WithBaseURL(api.URL))
+	require.NoError(t, err)
+	s, err := New(k, cfg)
+	require.NoError(t, err)
+	proxy := httptest.NewServer(s)
+	t.Cleanup(proxy.Close)
+	return api, proxy
+}
+
+func post(t *testing.T, url, body string) *http.Response {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// decodeError returns the code of an OpenAI error response.
+func decodeError(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	var body struct {
+		Error apiError `json:"error"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.NotNil(t, body.Error.Code)
+	assert.NotEmpty(t, body.Error.Message)
+	return *body.Error.Code
+}
+
+// readEvents returns the data of all events of an SSE response.
+func readEvents(t *testing.T, resp *http.Response) []string {
+	t.Helper()
+	var events []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			events = append(events, data)
+		}
+	}
+	require.NoError(t, scanner.Err())
+	return events
+}
+
+func TestModels(t *testing.T) {
+	_, proxy := newTestProxy(t, Config{AIIDs: []string{testAIID, otherAIID}})
+
+	resp, err := http.Get(proxy.URL + "/v1/models")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var list struct {
+		Object string  `json:"object"`
+		Data   []model `json:"data"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	assert.Equal(t, "list", list.Object)
+	require.Len(t, list.Data, 2)
+	assert.Equal(t, testAIID, list.Data[0].ID)
+	assert.Equal(t, otherAIID, list.Data[1].ID)
+	assert.Equal(t, "kindroid", list.Data[1].OwnedBy)
+
+	resp, err = http.Get(proxy.URL + "/v1/models/" + otherAIID)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Get(proxy.URL + "/v1/models/unknown")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "model_not_found", decodeError(t, resp))
+}
+
+func TestChatCompletions(t *testing.T) {
+	api, proxy := newTestProxy(t, Config{AIIDs: []string{testAIID, otherAIID}})
+
+	resp := post(t, proxy.URL+"/v1/chat/completions", `{
+		"model": "other_ai_id",
+		"messages": [
+			{"role": "system", "content": "You are helpful."},
+			{"role": "user", "content": "Earlier message"},
+			{"role": "assistant", "content": "Earlier reply"},
+			{"role": "user", "content": [{"type": "text", "text": "Hello there"}]}
+		]
+	}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var completion chatCompletion
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&completion))
+	assert.Equal(t, "chat.completion", completion.Object)
+	assert.Equal(t, otherAIID, completion.Model)
+	assert.True(t, strings.HasPrefix(completion.ID, "chatcmpl-"))
+	require.Len(t, completion.Choices, 1)
+	assert.Equal(t, "assistant", completion.Choices[0].Message.Role)
+	assert.Equal(t, "You said: Hello there", completion.Choices[0].Message.Content)
+	assert.Equal(t, "stop", *completion.Choices[0].FinishReason)
+
+	// Only the last user message is forwarded, since Kindroid keeps the history itself
+	path, request := api.lastRequest()
+	assert.Equal(t, "/send-message", path)
+	assert.Equal(t, otherAIID, request["ai_id"])
+	assert.Equal(t, "Hello there", request["message"])
+
+	// Without a model, the first AI is used
+	resp = post(t, proxy.URL+"/v1/chat/completions", `{"messages": [{"role": "user", "content": "Hi"}]}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	_, request = api.lastRequest()
+	assert.Equal(t, testAIID, request["ai_id"])
+}
+
+func TestChatCompletionsStream(t *testing.T) {
+	_, proxy := newTestProxy(t, Config{})
+
+	resp := post(t, proxy.URL+"/v1/chat/completions",
+		`{"model": "test_ai_id", "stream": true, "messages": [{"role": "user", "content": "Hello there"}]}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	events := readEvents(t, resp)
+	require.GreaterOrEqual(t, len(events), 4)
+	assert.Equal(t, "[DONE]", events[len(events)-1])
+
+	var content strings.Builder
+	var ids []string
+	for i, event := range events[:len(events)-1] {
+		var chunk chatCompletion
+		require.NoError(t, json.Unmarshal([]byte(event), &chunk))
+		assert.Equal(t, "chat.completion.chunk", chunk.Object)
+		require.Len(t, chunk.Choices, 1)
+		choice := chunk.Choices[0]
+		ids = append(ids, chunk.ID)
+		switch i {
+		case 0:
+			assert.Equal(t, "assistant", choice.Delta.Role)
+		case len(events) - 2:
+			assert.Equal(t, "stop", *choice.FinishReason)
+		default:
+			assert.Nil(t, choice.FinishReason)
+			content.WriteString(choice.Delta.Content)
+		}
+	}
+	assert.Equal(t, "You said: Hello there", content.String())
+	for _, id := range ids {
+		assert.Equal(t, ids[0], id)
+	}
+}
+
+func TestChatCompletionsBreak(t *testing.T) {
+	api, proxy := newTestProxy(t, Config{BreakCommand: "/new"})
+
+	resp := post(t, proxy.URL+"/v1/chat/completions",
+		`{"messages": [{"role": "user", "content": "Hi"}, {"role": "system", "content": "/new Hello again!"}]}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var completion chatCompletion
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&completion))
+	assert.Equal(t, "Hello again!", completion.Choices[0].Message.Content)
+	path, request := api.lastRequest()
+	assert.Equal(t, "/chat-break", path)
+	assert.Equal(t, testAIID, request["ai_id"])
+	assert.Equal(t, "Hello again!", request["greeting"])
+
+	// Streamed as a single delta
+	resp = post(t, proxy.URL+"/v1/chat/completions",
+		`{"stream": true, "messages": [{"role": "user", "content": "/new Welcome back"}]}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	events := readEvents(t, resp)
+	assert.Contains(t, events[1], "Welcome back")
+	assert.Equal(t, "[DONE]", events[len(events)-1])
+
+	resp = post(t, proxy.URL+"/v1/chat/completions", `{"messages": [{"role": "user", "content": "/new"}]}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "missing_greeting", decodeError(t, resp))
+
+	// Only the exact command triggers a chat break
+	resp = post(t, proxy.URL+"/v1/chat/completions", `{"messages": [{"role": "user", "content": "/newspaper"}]}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	path, _ = api.lastRequest()
+	assert.Equal(t, "/send-message", path)
+}
+
+func TestChatCompletionsErrors(t *testing.T) {
+	_, proxy := newTestProxy(t, Config{})
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+	}{
+		{"invalid JSON", `{"messages": [`, http.StatusBadRequest, "invalid_json"},
+		{"unknown model", `{"model": "gpt-4", "messages": [{"role": "user", "content": "Hi"}]}`, http.StatusNotFound, "model_not_found"},
+		{"no user message", `{"messages": [{"role": "system", "content": "Be nice"}]}`, http.StatusBadRequest, "missing_user_message"},
+		{"rate limited", `{"messages": [{"role": "user", "content": "slow down"}]}`, http.StatusTooManyRequests, "rate_limit_exceeded"},
+		{"upstream unauthorized", `{"messages": [{"role": "user", "content": "forbidden"}]}`, http.StatusBadGateway, "upstream_unauthorized"},
+		{"stream rate limited", `{"stream": true, "messages": [{"role": "user", "content": "slow down"}]}`, http.StatusTooManyRequests, "rate_limit_exceeded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := post(t, proxy.URL+"/v1/chat/completions", tt.body)
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Equal(t, tt.code, decodeError(t, resp))
+		})
+	}
+
+	resp, err := http.Get(proxy.URL + "/v1/completions")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "unknown_url", decodeError(t, resp))
+}
+
+func TestAPIKey(t *testing.T) {
+	_, proxy := newTestProxy(t, Config{APIKey: "secret"})
+
+	for _, auth := range []string{"", "Bearer wrong", "secret"} {
+		req, _ := http.NewRequest("GET", proxy.URL+"/v1/models", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, auth)
+		assert.Equal(t, "invalid_api_key", decodeError(t, resp))
+		resp.Body.Close()
+	}
+
+	req, _ := http.NewRequest("GET", proxy.URL+"/v1/models", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(client.NewKindroidAI("test_api_key", ""), Config{})
+	assert.Error(t, err)
+
+	s, err := New(client.NewKindroidAI("test_api_key", testAIID), Config{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{testAIID}, s.cfg.AIIDs)
+	assert.Equal(t, DefaultBreakCommand, s.cfg.BreakCommand)
+}
@@ -0,0 +1,193 @@
+// Package server
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/audio"
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/client"
+)
+
+// Response formats of the speech endpoint. Kindroid audio is MP3 and is converted for the other formats.
+const (
+	SpeechFormatMP3 = "mp3"
+	SpeechFormatWAV = "wav"
+	// SpeechFormatPCM is raw signed 16-bit little-endian mono PCM at 24kHz, like OpenAI's pcm format.
+	SpeechFormatPCM = "pcm"
+)
+
+// pcmSampleRate is the sample rate of SpeechFormatPCM.
+const pcmSampleRate = 24000
+
+// speechLookupSize is the number of recent messages searched for the input text of a speech request.
+const speechLookupSize = 50
+
+// speechBackend provides the audio of chat messages; it is implemented by *client.KindroidAI.
+type speechBackend interface {
+	AudioStreamAI(ctx context.Context, aiID, messageID string) (io.ReadCloser, client.AudioInfo, error)
+	ListChatMessages(ctx context.Context, aiID string, opts client.ListOptions) (*client.ChatMessagePage, error)
+}
+
+// speechRequest contains the supported fields of a speech request.
+// Kindroid only voices its own messages, so instead of synthesizing arbitrary text, the input selects
+// a recent AI message with this text, e.g. a reply just returned by the chat completions endpoint.
+// Alternatively, the message can be selected by ID. The voice is the one configured for the AI,
+// so the voice field is ignored.
+type speechRequest struct {
+	Model          string  `json:"model"`
+	Input          string  `json:"input"`
+	MessageID      string  `json:"message_id"`
+	ResponseFormat string  `json:"response_format"`
+	Speed          float64 `json:"speed"`
+}
+
+func (s *Server) handleSpeech(w http.ResponseWriter, r *http.Request) {
+	var req speechRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid_json", "Invalid request body: "+err.Error())
+		return
+	}
+	aiID, ok := s.resolveModel(req.Model)
+	if !ok {
+		writeModelNotFound(w, req.Model)
+		return
+	}
+	if req.ResponseFormat == "" {
+		req.ResponseFormat = SpeechFormatMP3
+	}
+	switch {
+	case req.ResponseFormat != SpeechFormatMP3 && req.ResponseFormat != SpeechFormatWAV && req.ResponseFormat != SpeechFormatPCM:
+		writeParamError(w, "response_format", "unsupported_response_format",
+			fmt.Sprintf("The response format '%s' is not supported; use mp3, wav or pcm.", req.ResponseFormat))
+		return
+	case req.Speed != 0 && req.Speed != 1:
+		writeParamError(w, "speed", "unsupported_speed", "Only the original speed of 1.0 is supported.")
+		return
+	case req.MessageID == "" && strings.TrimSpace(req.Input) == "":
+		writeParamError(w, "input", "missing_input", "Either input or message_id is required.")
+		return
+	}
+
+	messageID := req.MessageID
+	if messageID == "" {
+		var err error
+		if messageID, err = s.findMessage(r.Context(), aiID, req.Input); err != nil {
+			s.writeUpstreamError(w, r, err)
+			return
+		}
+		if messageID == "" {
+			writeParamError(w, "input", "message_not_found", "No recent message of the AI matches the input.")
+			return
+		}
+	}
+
+	stream, _, err := s.speech.AudioStreamAI(r.Context(), aiID, messageID)
+	if err != nil {
+		s.writeUpstreamError(w, r, err)
+		return
+	}
+	defer stream.Close()
+
+	switch req.ResponseFormat {
+	case SpeechFormatMP3:
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.WriteHeader(http.StatusOK)
+		if err = copyFlush(w, stream); err != nil && r.Context().Err() == nil {
+			s.cfg.Logger.Warn("failed to stream audio", "message_id", messageID, "error", err)
+		}
+	default:
+		s.writeConvertedSpeech(w, r, req.ResponseFormat, messageID, stream)
+	}
+}
+
+// writeConvertedSpeech decodes the MP3 audio and writes it in the given format.
+// The audio is converted completely before writing, so decoding errors can still be reported.
+func (s *Server) writeConvertedSpeech(w http.ResponseWriter, r *http.Request, format, messageID string, stream io.Reader) {
+	pcm, err := audio.Decode(stream)
+	if err != nil {
+		if r.Context().Err() == nil {
+			s.cfg.Logger.Warn("failed to decode audio", "message_id", messageID, "error", err)
+		}
+		writeError(w, http.StatusBadGateway, "server_error", "invalid_audio", "The audio returned by Kindroid could not be decoded.")
+		return
+	}
+
+	var body bytes.Buffer
+	contentType := "audio/wav"
+	if format == SpeechFormatPCM {
+		if pcm, err = pcm.Mono().Resample(pcmSampleRate); err == nil {
+			body.Write(pcm.Bytes())
+		}
+		contentType = fmt.Sprintf("audio/L16; rate=%d; channels=1", pcmSampleRate)
+	} else {
+		err = audio.WriteWAV(&body, pcm)
+	}
+	if err != nil {
+		s.cfg.Logger.Warn("failed to convert audio", "message_id", messageID, "format", format, "error", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "conversion_failed", "The audio could not be converted.")
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	copyFlush(w, &body)
+}
+
+// findMessage returns the ID of the most recent AI message with the given text, or "" if there is none.
+func (s *Server) findMessage(ctx context.Context, aiID, text string) (string, error) {
+	page, err := s.speech.ListChatMessages(ctx, aiID, client.ListOptions{PageSize: speechLookupSize})
+	if err != nil {
+		return "", err
+	}
+	text = strings.TrimSpace(text)
+	for _, message := range page.Messages {
+		if message.Sender == client.SenderAI && strings.TrimSpace(message.Message) == text {
+			return message.ID, nil
+		}
+	}
+	return "", nil
+}
+
+// streamChunkSize is the size of the chunks in which audio is written to the client.
+const streamChunkSize = 16 << 10
+
+// copyFlush copies r to the response, flushing after each chunk, so clients can start playback early.
+func copyFlush(w http.ResponseWriter, r io.Reader) error {
+	rc := http.NewResponseController(w)
+	buf := make([]byte, streamChunkSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, errWrite := w.Write(buf[:n]); errWrite != nil {
+				return errWrite
+			}
+			rc.Flush()
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
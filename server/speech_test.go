@@ -0,0 +1,142 @@
+// Package server
+/*
+Copyright © 2024 Harmony AI Solutions & Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/audio"
+	"github.com/harmony-ai-solutions/KindroidAI-Golang/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSpeech serves the MP3 fixture of the audio package for the messages of testAIID.
+type fakeSpeech struct {
+	mp3      []byte
+	messages []*client.ChatMessage
+	aiID     string
+}
+
+func (f *fakeSpeech) AudioStreamAI(_ context.Context, aiID, messageID string) (io.ReadCloser, client.AudioInfo, error) {
+	f.aiID = aiID
+	for _, message := range f.messages {
+		if message.ID == messageID {
+			return io.NopCloser(bytes.NewReader(f.mp3)), client.AudioInfo{MessageID: messageID, ContentType: "audio/mpeg"}, nil
+		}
+	}
+	return nil, client.AudioInfo{}, &client.APIError{StatusCode: http.StatusNotFound, Endpoint: "audio"}
+}
+
+func (f *fakeSpeech) ListChatMessages(_ context.Context, aiID string, _ client.ListOptions) (*client.ChatMessagePage, error) {
+	f.aiID = aiID
+	return &client.ChatMessagePage{Messages: f.messages}, nil
+}
+
+func newSpeechProxy(t *testing.T) (*fakeSpeech, *httptest.Server) {
+	mp3, err := os.ReadFile("../audio/testdata/speech.mp3")
+	require.NoError(t, err)
+	fake := &fakeSpeech{mp3: mp3, messages: []*client.ChatMessage{
+		{ID: "newest", Sender: client.SenderUser, Message: "Hello there"},
+		{ID: "reply", Sender: client.SenderAI, Message: "Hello there"},
+		{ID: "older", Sender: client.SenderAI, Message: "Hello there"},
+	}}
+	s, err := New(client.NewKindroidAI("test_api_key", testAIID), Config{AIIDs: []string{testAIID, otherAIID}})
+	require.NoError(t, err)
+	s.speech = fake
+	proxy := httptest.NewServer(s)
+	t.Cleanup(proxy.Close)
+	return fake, proxy
+}
+
+func TestSpeech(t *testing.T) {
+	fake, proxy := newSpeechProxy(t)
+
+	// The most recent AI message with the input text is voiced
+	resp := post(t, proxy.URL+"/v1/audio/speech", `{"model": "other_ai_id", "input": " Hello there\n", "voice": "alloy"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "audio/mpeg", resp.Header.Get("Content-Type"))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, fake.mp3, data)
+	assert.Equal(t, otherAIID, fake.aiID)
+
+	resp = post(t, proxy.URL+"/v1/audio/speech", `{"message_id": "older", "response_format": "wav"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "audio/wav", resp.Header.Get("Content-Type"))
+	data, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "RIFF", string(data[:4]))
+	assert.Equal(t, "WAVE", string(data[8:12]))
+	assert.Equal(t, testAIID, fake.aiID)
+
+	resp = post(t, proxy.URL+"/v1/audio/speech", `{"message_id": "reply", "response_format": "pcm"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "audio/L16; rate=24000; channels=1", resp.Header.Get("Content-Type"))
+	data, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	decoded, err := audio.Decode(bytes.NewReader(fake.mp3))
+	require.NoError(t, err)
+	pcm, err := audio.NewBuffer(audio.Format{SampleRate: pcmSampleRate, Channels: 1}, data)
+	require.NoError(t, err)
+	assert.InDelta(t, decoded.Duration().Seconds(), pcm.Duration().Seconds(), 0.001)
+}
+
+func TestSpeechErrors(t *testing.T) {
+	_, proxy := newSpeechProxy(t)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+	}{
+		{"invalid JSON", `{"input": `, http.StatusBadRequest, "invalid_json"},
+		{"unknown model", `{"model": "tts-1", "input": "Hello there"}`, http.StatusNotFound, "model_not_found"},
+		{"unsupported format", `{"input": "Hello there", "response_format": "opus"}`, http.StatusBadRequest, "unsupported_response_format"},
+		{"unsupported speed", `{"input": "Hello there", "speed": 1.5}`, http.StatusBadRequest, "unsupported_speed"},
+		{"no input", `{"input": " "}`, http.StatusBadRequest, "missing_input"},
+		{"unknown input", `{"input": "Goodbye"}`, http.StatusBadRequest, "message_not_found"},
+		{"unknown message", `{"message_id": "missing"}`, http.StatusNotFound, "not_found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := post(t, proxy.URL+"/v1/audio/speech", tt.body)
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Equal(t, tt.code, decodeError(t, resp))
+		})
+	}
+}
+
+func TestSpeechRequiresJWT(t *testing.T) {
+	// A plain API key cannot access the chat history and audio
+	_, proxy := newTestProxy(t, Config{})
+
+	for _, body := range []string{`{"input": "Hello there"}`, `{"message_id": "reply"}`} {
+		resp := post(t, proxy.URL+"/v1/audio/speech", body)
+		assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+		assert.Equal(t, "jwt_required", decodeError(t, resp))
+	}
+}